@@ -3,6 +3,7 @@ import { NextRequest } from "next/server";
 export const dynamic = "force-dynamic";
 
 const BACKEND_URL = process.env.BACKEND_URL || "http://api:8089";
+const ADMIN_TOKEN = process.env.ADMIN_TOKEN || "";
 
 async function proxyRequest(req: NextRequest, path: string) {
   const url = new URL(req.url);
@@ -13,6 +14,7 @@ async function proxyRequest(req: NextRequest, path: string) {
       method: req.method,
       headers: {
         "Content-Type": "application/json",
+        Authorization: `Bearer ${ADMIN_TOKEN}`,
       },
       body: req.method !== "GET" ? await req.text() : undefined,
       cache: "no-store",
@@ -52,3 +54,19 @@ export async function POST(
   const { path } = await params;
   return proxyRequest(req, path.join("/"));
 }
+
+export async function PUT(
+  req: NextRequest,
+  { params }: { params: Promise<{ path: string[] }> }
+) {
+  const { path } = await params;
+  return proxyRequest(req, path.join("/"));
+}
+
+export async function DELETE(
+  req: NextRequest,
+  { params }: { params: Promise<{ path: string[] }> }
+) {
+  const { path } = await params;
+  return proxyRequest(req, path.join("/"));
+}
@@ -7,6 +7,7 @@ import { RequestsList } from "@/components/RequestsList";
 import { Pagination } from "@/components/Pagination";
 import { DurationToggle } from "@/components/DurationToggle";
 import { RequestsChart } from "@/components/RequestsChart";
+import { SpendChart } from "@/components/SpendChart";
 import { RefreshCw } from "lucide-react";
 
 export default function Home() {
@@ -99,6 +100,8 @@ export default function Home() {
 
         <RequestsChart data={timeSeriesData} duration={duration} />
 
+        <SpendChart data={timeSeriesData} duration={duration} />
+
         <div>
           <h2 className="text-lg font-semibold mb-4">Requests</h2>
 
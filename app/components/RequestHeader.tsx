@@ -16,7 +16,11 @@ export function RequestHeader({ request }: RequestHeaderProps) {
         )}
 
         <div className="flex-1 min-w-0">
-          <div className="font-medium truncate">{request.Model}</div>
+          <div className="font-medium truncate">
+            {request.RequestedModel && request.RequestedModel !== request.Model
+              ? `${request.RequestedModel} → ${request.Model}`
+              : request.Model}
+          </div>
           <div className="text-xs text-gray-500 mt-1">
             {new Date(request.Timestamp).toLocaleString()}
           </div>
@@ -32,7 +36,7 @@ export function RequestHeader({ request }: RequestHeaderProps) {
         )}
         <div className="flex items-center gap-1.5">
           <Database className="w-3.5 h-3.5 text-gray-400" />
-          <span>{request.CacheHit ? "Yes" : "No"}</span>
+          <span>{request.CacheHit ? request.CacheSource || "Yes" : "No"}</span>
         </div>
         <div className="flex items-center gap-1.5">
           <Key className="w-3.5 h-3.5 text-gray-400" />
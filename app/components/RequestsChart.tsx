@@ -6,8 +6,8 @@ interface RequestsChartProps {
   duration?: "24h" | "7d";
 }
 
-function fillMissingBuckets(data: TimeSeriesData[], duration: "24h" | "7d"): TimeSeriesData[] {
-  const dataMap = new Map((data || []).map((d) => [d.timestamp, d.count]));
+export function fillMissingBuckets(data: TimeSeriesData[], duration: "24h" | "7d"): TimeSeriesData[] {
+  const dataMap = new Map((data || []).map((d) => [d.timestamp, d]));
   const filled: TimeSeriesData[] = [];
 
   const now = new Date();
@@ -26,10 +26,7 @@ function fillMissingBuckets(data: TimeSeriesData[], duration: "24h" | "7d"): Tim
       timestamp = `${date.toISOString().split("T")[0]} ${String(date.getHours()).padStart(2, "0")}:00`;
     }
 
-    filled.push({
-      timestamp,
-      count: dataMap.get(timestamp) || 0,
-    });
+    filled.push(dataMap.get(timestamp) || { timestamp, count: 0, spent: 0, saved: 0 });
   }
 
   return filled;
@@ -61,13 +58,7 @@ export function RequestsChart({ data, duration = "24h" }: RequestsChartProps) {
                 style={{ height: `${heightPx}px` }}
               />
               <div className="text-xs text-gray-500 mt-2 text-center truncate">
-                {item.timestamp.includes(":")
-                  ? item.timestamp.split(" ")[1]
-                  : new Date(item.timestamp).toLocaleDateString(undefined, {
-                      month: "short",
-                      day: "numeric",
-                    })
-                }
+                {bucketLabel(item.timestamp)}
               </div>
             </div>
           );
@@ -76,3 +67,12 @@ export function RequestsChart({ data, duration = "24h" }: RequestsChartProps) {
     </div>
   );
 }
+
+export function bucketLabel(timestamp: string): string {
+  return timestamp.includes(":")
+    ? timestamp.split(" ")[1]
+    : new Date(timestamp).toLocaleDateString(undefined, {
+        month: "short",
+        day: "numeric",
+      });
+}
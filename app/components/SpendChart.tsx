@@ -0,0 +1,57 @@
+import { TimeSeriesData } from "@/types";
+import { useMemo } from "react";
+import { bucketLabel, fillMissingBuckets } from "./RequestsChart";
+
+interface SpendChartProps {
+  data: TimeSeriesData[];
+  duration?: "24h" | "7d";
+}
+
+// spent (upstream calls) stacked under saved (cache hits) per bucket
+export function SpendChart({ data, duration = "24h" }: SpendChartProps) {
+  const filledData = useMemo(() => fillMissingBuckets(data, duration), [data, duration]);
+  const maxTotal = Math.max(...filledData.map((d) => d.spent + d.saved), 0.000001);
+  const chartHeight = 192; // h-48 = 12rem = 192px
+
+  return (
+    <div className="border border-gray-200 rounded-lg p-4 bg-white">
+      <div className="flex items-center justify-between mb-4">
+        <div className="text-sm font-medium">Spent vs saved</div>
+        <div className="flex items-center gap-3 text-xs text-gray-500">
+          <span className="flex items-center gap-1">
+            <span className="w-2.5 h-2.5 rounded-sm bg-black" /> spent
+          </span>
+          <span className="flex items-center gap-1">
+            <span className="w-2.5 h-2.5 rounded-sm bg-gray-400" /> saved
+          </span>
+        </div>
+      </div>
+      <div className="flex items-end gap-1 h-48">
+        {filledData.map((item, idx) => {
+          const total = item.spent + item.saved;
+          const spentPx = (item.spent / maxTotal) * chartHeight;
+          const savedPx = (item.saved / maxTotal) * chartHeight;
+
+          return (
+            <div key={idx} className="flex-1 flex flex-col justify-end group relative min-w-0">
+              <div className="absolute -top-8 left-1/2 -translate-x-1/2 opacity-0 group-hover:opacity-100 text-xs bg-black text-white px-2 py-1 rounded whitespace-nowrap z-10">
+                ${item.spent.toFixed(4)} spent, ${item.saved.toFixed(4)} saved
+              </div>
+              {total === 0 ? (
+                <div className="bg-gray-300 rounded-t" style={{ height: "4px" }} />
+              ) : (
+                <>
+                  <div className="bg-gray-400 rounded-t" style={{ height: `${savedPx}px` }} />
+                  <div className="bg-black" style={{ height: `${spentPx}px` }} />
+                </>
+              )}
+              <div className="text-xs text-gray-500 mt-2 text-center truncate">
+                {bucketLabel(item.timestamp)}
+              </div>
+            </div>
+          );
+        })}
+      </div>
+    </div>
+  );
+}
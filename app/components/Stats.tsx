@@ -1,5 +1,5 @@
 import { Stats as StatsType } from "@/types";
-import { Activity, CheckCircle, XCircle, Database, DollarSign, Clock } from "lucide-react";
+import { Activity, CheckCircle, XCircle, Database, DollarSign, PiggyBank, Clock } from "lucide-react";
 
 interface StatsProps {
   stats: StatsType;
@@ -7,12 +7,13 @@ interface StatsProps {
 
 export function Stats({ stats }: StatsProps) {
   return (
-    <div className="grid grid-cols-2 md:grid-cols-3 lg:grid-cols-6 gap-3 mb-6">
+    <div className="grid grid-cols-2 md:grid-cols-3 lg:grid-cols-7 gap-3 mb-6">
       <StatCard icon={Activity} label="Total" value={stats.total_requests.toLocaleString()} />
       <StatCard icon={CheckCircle} label="Success" value={stats.successful_requests.toLocaleString()} />
       <StatCard icon={XCircle} label="Failed" value={stats.failed_requests.toLocaleString()} />
       <StatCard icon={Database} label="Cached" value={stats.cache_hits.toLocaleString()} />
-      <StatCard icon={DollarSign} label="Cost" value={`$${stats.total_cost.toFixed(6)}`} />
+      <StatCard icon={DollarSign} label="Spent" value={`$${stats.total_spent.toFixed(6)}`} />
+      <StatCard icon={PiggyBank} label="Saved" value={`$${stats.total_saved.toFixed(6)}`} />
       <StatCard icon={Clock} label="Latency" value={`${stats.avg_response_time_ms}ms`} />
     </div>
   );
@@ -4,13 +4,34 @@ export interface Stats {
   failed_requests: number;
   cache_hits: number;
   total_cost: number;
+  total_spent: number;
+  total_saved: number;
   avg_response_time_ms: number;
+  by_virtual_key?: VirtualKeyStats[];
+}
+
+export interface VirtualKeyStats {
+  virtual_key_id: string;
+  name: string;
+  team: string;
+  project: string;
+  requests: number;
+  cost: number;
+}
+
+export interface CostItem {
+  Kind: "input" | "cached_input" | "tool_use_input" | "output" | "thoughts";
+  Modality: string;
+  Tokens: number;
+  Rate: number;
+  Cost: number;
 }
 
 export interface RequestLog {
   ID: string;
   Timestamp: string;
   Model: string;
+  RequestedModel?: string;
   Request: any;
   Response?: any;
   StatusCode: number;
@@ -20,16 +41,23 @@ export interface RequestLog {
     Input: number;
     Output: number;
     Total: number;
+    Breakdown?: CostItem[];
   };
+  Spent: number;
+  Saved: number;
   Temperature: number;
   KeySource: string;
   CacheHit: boolean;
+  CacheSource?: string;
+  Similarity?: number;
   RequestHash: string;
+  NoCache?: boolean;
   DurationMs: number;
   PromptTokens: number;
   OutputTokens: number;
   TotalTokens: number;
   IsVision: boolean;
+  VirtualKeyID?: string;
 }
 
 export interface RequestsResponse {
@@ -43,4 +71,6 @@ export interface RequestsResponse {
 export interface TimeSeriesData {
   timestamp: string;
   count: number;
+  spent: number;
+  saved: number;
 }
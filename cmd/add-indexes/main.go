@@ -31,11 +31,13 @@ func main() {
 
 	indexes := []mongo.IndexModel{
 		{
+			// the store fallback wants the newest matching log
 			Keys: bson.D{
 				{Key: "request_hash", Value: 1},
 				{Key: "success", Value: 1},
+				{Key: "timestamp", Value: -1},
 			},
-			Options: options.Index().SetName("cache_lookup"),
+			Options: options.Index().SetName("cache_lookup_newest"),
 		},
 		{
 			Keys:    bson.D{{Key: "timestamp", Value: -1}},
@@ -63,6 +65,13 @@ func main() {
 			},
 			Options: options.Index().SetName("timestamp_cache_hit"),
 		},
+		{
+			Keys: bson.D{
+				{Key: "virtual_key_id", Value: 1},
+				{Key: "timestamp", Value: -1},
+			},
+			Options: options.Index().SetName("virtual_key_spend").SetSparse(true),
+		},
 	}
 
 	log.Printf("creating indexes on %s.%s", cfg.MongoDB.Database, cfg.MongoDB.Collection)
@@ -73,4 +82,23 @@ func main() {
 	}
 
 	log.Printf("created indexes: %v", names)
+
+	// cache_lookup_newest supersedes it
+	if _, err := collection.Indexes().DropOne(ctx, "cache_lookup"); err == nil {
+		log.Printf("dropped index cache_lookup")
+	}
+
+	virtualKeys := client.Database(cfg.MongoDB.Database).Collection(cfg.MongoDB.VirtualKeysCollection)
+
+	names, err = virtualKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetName("token_hash").SetUnique(true),
+		},
+	})
+	if err != nil {
+		log.Fatalf("failed to create virtual key indexes: %v", err)
+	}
+
+	log.Printf("created virtual key indexes: %v", names)
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/keymanager"
+
+	"gopkg.in/yaml.v3"
+)
+
+// check-config validates config.yaml and keys.csv offline, without touching
+// redis, mongodb or gemini, and prints the effective config (env included)
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to config.yaml")
+	csvPath := flag.String("keys", "", "path to keys.csv (default keys.path from config)")
+	flag.Parse()
+
+	ok := true
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		ok = false
+		fmt.Fprintf(os.Stderr, "✗ %v\n\n", err)
+	} else {
+		fmt.Printf("✓ %s is valid, effective config:\n\n", *configPath)
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(cfg.Masked()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print config: %v\n", err)
+			os.Exit(1)
+		}
+		enc.Close()
+		fmt.Println()
+		printEnvOverrides()
+	}
+	if *csvPath == "" {
+		*csvPath = "data/keys.csv"
+		if cfg != nil {
+			*csvPath = cfg.Keys.Path
+		}
+	}
+
+	keys, err := keymanager.ReadKeys(*csvPath)
+	switch {
+	case os.IsNotExist(err):
+		fmt.Printf("- %s not found, only user-provided api keys will work\n", *csvPath)
+	case err != nil:
+		ok = false
+		fmt.Fprintf(os.Stderr, "✗ invalid %s:\n%v\n", *csvPath, err)
+	default:
+		printKeys(*csvPath, keys, cfg)
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// printEnvOverrides shows which settings come from the env instead of the file
+func printEnvOverrides() {
+	var set []string
+	for _, v := range config.EnvVars() {
+		name, path, _ := strings.Cut(v, "=")
+		if os.Getenv(name) != "" {
+			set = append(set, fmt.Sprintf("  %s overrides %s", name, path))
+		}
+	}
+	if len(set) > 0 {
+		fmt.Printf("env overrides:\n%s\n\n", strings.Join(set, "\n"))
+	}
+}
+
+func printKeys(csvPath string, keys []keymanager.Key, cfg *config.Config) {
+	active := 0
+	for _, key := range keys {
+		if key.Active {
+			active++
+		}
+	}
+	fmt.Printf("✓ %s is valid: %d keys, %d active\n\n", csvPath, len(keys), active)
+	if active == 0 {
+		fmt.Println("warning: no active keys, only user-provided api keys will work")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKEY\tACTIVE\tWORKING MODELS\tNOT IN CONFIG")
+	for _, key := range keys {
+		var unknown []string
+		if cfg != nil {
+			for _, model := range key.Models() {
+				if _, exists := cfg.GetModelCost(model); !exists {
+					unknown = append(unknown, model)
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", key.ID(), key.Masked(), key.Active,
+			strings.Join(key.Models(), ","), strings.Join(unknown, ","))
+	}
+	w.Flush()
+}
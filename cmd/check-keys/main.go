@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/keymanager"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to config.yaml")
+	csvPath := flag.String("keys", "", "path to keys.csv (default keys.path from config)")
+	reactivate := flag.Bool("reactivate", false, "re-enable inactive keys that pass the check")
+	dryRun := flag.Bool("dry-run", false, "print results without updating the csv")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if *csvPath == "" {
+		*csvPath = cfg.Keys.Path
+	}
+
+	km, err := keymanager.New(*csvPath)
+	if err != nil {
+		log.Fatalf("failed to load keys: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	keys := km.List()
+	log.Printf("checking %d keys against %d models", len(keys), len(cfg.Costs.Models))
+
+	results := km.CheckAll(ctx, keymanager.NewChecker(config.NewHolder(*configPath, cfg)))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tACTIVE\tRESULT\tWORKING MODELS")
+	for _, key := range keys {
+		result := results[key.Value]
+		status := "inconclusive"
+		switch {
+		case result.Valid:
+			status = "ok"
+		case result.Rejected:
+			status = "rejected"
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\n", key.Masked(), key.Active, status, strings.Join(result.WorkingModels, ","))
+	}
+	w.Flush()
+
+	if *dryRun {
+		return
+	}
+
+	if err := km.ApplyChecks(results, *reactivate); err != nil {
+		log.Fatalf("failed to update keys: %v", err)
+	}
+
+	log.Printf("updated %s: %d active keys", *csvPath, km.ActiveCount())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/store"
+)
+
+// migrate-hashes rewrites logged request hashes to the current hash version
+// (store.HashVersion). safe to rerun, logs already on it are skipped
+func main() {
+	cfg, err := config.Load("config.yaml")
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	var migrator store.HashMigrator
+	switch cfg.Storage.Backend {
+	case "bolt":
+		s, err := store.NewBoltStore(cfg.Storage.Path)
+		if err != nil {
+			log.Fatalf("failed to open bolt store: %v", err)
+		}
+		defer s.Close()
+		migrator = s
+	default:
+		s, err := store.NewMongoStore(cfg)
+		if err != nil {
+			log.Fatalf("failed to connect to mongodb: %v", err)
+		}
+		defer s.Close()
+		migrator = s
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	log.Printf("migrating %s request hashes to %s", cfg.Storage.Backend, store.HashVersion)
+	// v2 hashes cover the model, logs are hashed for the model their requested
+	// name resolves to with the current aliases
+	migrated, err := migrator.MigrateHashes(ctx, cfg.ResolvedName)
+	if err != nil {
+		log.Fatalf("migrated %d logs before failing: %v", migrated, err)
+	}
+	log.Printf("migrated %d logs", migrated)
+}
@@ -0,0 +1,86 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultThreshold is how many consecutive failures open a breaker
+	DefaultThreshold = 5
+	// DefaultCooldown is how long an open breaker skips calls before probing
+	DefaultCooldown = 30 * time.Second
+)
+
+// Breaker is a consecutive-failure circuit breaker. once open it rejects calls
+// until the cooldown passes, then lets a single probe through; the probe's
+// result closes it again or restarts the cooldown
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastErr   error
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may go through. every allowed call must be
+// followed by Success, Failure or Release
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.lastErr = nil
+}
+
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	b.lastErr = err
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// Release ends a call that says nothing about the dependency, e.g. one its
+// caller gave up on. a probe released this way lets the next call probe
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Open is true while calls are being rejected or probed
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold
+}
+
+func (b *Breaker) LastError() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastErr
+}
@@ -0,0 +1,106 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	b := New(2, 10*time.Millisecond)
+	fail := errors.New("down")
+
+	b.Failure(fail)
+	if !b.Allow() {
+		t.Fatal("expected breaker to stay closed below the threshold")
+	}
+	b.Failure(fail)
+	if b.Allow() {
+		t.Fatal("expected open breaker to reject calls")
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("expected a probe after the cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("expected only one probe at a time")
+	}
+
+	b.Failure(fail)
+	if b.Allow() {
+		t.Fatal("expected a failed probe to restart the cooldown")
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	b.Allow()
+	b.Success()
+	if b.Open() || !b.Allow() {
+		t.Fatal("expected a successful probe to close the breaker")
+	}
+}
+
+func TestDependencyReconnects(t *testing.T) {
+	attempts := 0
+	connect := func() (string, error) {
+		attempts++
+		if attempts < 3 {
+			return "", errors.New("refused")
+		}
+		return "conn", nil
+	}
+
+	d := NewDependency("test", connect, 5*time.Millisecond)
+	defer d.Close()
+
+	if _, ok := d.Acquire(); ok {
+		t.Fatal("expected no connection before reconnect")
+	}
+	if h := d.Health(); h.Status != StatusDegraded || h.Error != "refused" {
+		t.Fatalf("expected degraded health, got %+v", h)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if conn, ok := d.Acquire(); ok {
+			d.Done(context.Background(), nil)
+			if conn != "conn" {
+				t.Fatalf("unexpected connection %q", conn)
+			}
+			if h := d.Health(); h.Status != StatusOK {
+				t.Fatalf("expected ok health after reconnect, got %+v", h)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("dependency never reconnected")
+}
+
+func TestDependencyIgnoresCanceledCalls(t *testing.T) {
+	d := NewDependency("test", func() (string, error) { return "conn", nil }, time.Second)
+	defer d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < DefaultThreshold; i++ {
+		if _, ok := d.Acquire(); !ok {
+			t.Fatal("expected canceled calls not to open the breaker")
+		}
+		d.Done(ctx, fmt.Errorf("get: %w", context.Canceled))
+	}
+	if h := d.Health(); h.Status != StatusOK {
+		t.Fatalf("expected ok health, got %+v", h)
+	}
+
+	// the same error on a live context is the dependency timing out
+	for i := 0; i < DefaultThreshold; i++ {
+		d.Acquire()
+		d.Done(context.Background(), context.DeadlineExceeded)
+	}
+	if h := d.Health(); h.Status != StatusDegraded {
+		t.Fatalf("expected degraded health, got %+v", h)
+	}
+}
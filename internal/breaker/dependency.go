@@ -0,0 +1,152 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultRetryInterval is how often a missing dependency is reconnected
+const DefaultRetryInterval = 10 * time.Second
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+)
+
+// Health is the state of one dependency as reported by /health
+type Health struct {
+	Backend string `json:"backend"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Reporter is implemented by caches and stores that can be degraded
+type Reporter interface {
+	Health() Health
+}
+
+// Dependency is a connection that may not exist yet. if the first connect
+// fails it keeps retrying in the background, and once connected its calls go
+// through a breaker so a dependency that dies mid-flight is skipped quickly
+type Dependency[T any] struct {
+	backend string
+	breaker *Breaker
+
+	mu         sync.RWMutex
+	conn       T
+	connected  bool
+	connectErr error
+	stop       chan struct{}
+}
+
+func NewDependency[T any](backend string, connect func() (T, error), retry time.Duration) *Dependency[T] {
+	d := &Dependency[T]{
+		backend: backend,
+		breaker: New(DefaultThreshold, DefaultCooldown),
+		stop:    make(chan struct{}),
+	}
+
+	conn, err := connect()
+	if err == nil {
+		d.conn, d.connected = conn, true
+		log.Printf("connected to %s", backend)
+		return d
+	}
+
+	log.Printf("warning: %s unavailable, running degraded: %v", backend, err)
+	d.connectErr = err
+	go d.reconnect(connect, retry)
+	return d
+}
+
+func (d *Dependency[T]) reconnect(connect func() (T, error), retry time.Duration) {
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+
+		conn, err := connect()
+		d.mu.Lock()
+		if err != nil {
+			d.connectErr = err
+			d.mu.Unlock()
+			continue
+		}
+		d.conn, d.connected, d.connectErr = conn, true, nil
+		d.mu.Unlock()
+
+		log.Printf("%s reconnected", d.backend)
+		return
+	}
+}
+
+// Acquire returns the connection if it exists and the breaker allows a call.
+// the caller must report the outcome with Done
+func (d *Dependency[T]) Acquire() (T, bool) {
+	d.mu.RLock()
+	conn, connected := d.conn, d.connected
+	d.mu.RUnlock()
+
+	if !connected || !d.breaker.Allow() {
+		var zero T
+		return zero, false
+	}
+	return conn, true
+}
+
+// Done records the outcome of a call made with an acquired connection. a call
+// that failed because ctx, the caller's context, is done counts neither way
+func (d *Dependency[T]) Done(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		d.breaker.Release()
+		return
+	}
+	if err != nil {
+		if !d.breaker.Open() {
+			log.Printf("%s call failed: %v", d.backend, err)
+		}
+		d.breaker.Failure(err)
+		return
+	}
+	d.breaker.Success()
+}
+
+func (d *Dependency[T]) Health() Health {
+	d.mu.RLock()
+	connected, connectErr := d.connected, d.connectErr
+	d.mu.RUnlock()
+
+	health := Health{Backend: d.backend, Status: StatusOK}
+	switch {
+	case !connected:
+		health.Status = StatusDegraded
+		health.Error = connectErr.Error()
+	case d.breaker.Open():
+		health.Status = StatusDegraded
+		if err := d.breaker.LastError(); err != nil {
+			health.Error = err.Error()
+		}
+	}
+	return health
+}
+
+// Close stops reconnecting and returns the connection, if there is one, so
+// the owner can close it
+func (d *Dependency[T]) Close() (T, bool) {
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.conn, d.connected
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"ai-wrap/internal/breaker"
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/models"
+)
+
+// Cache stores responses by request hash. a miss is (nil, nil)
+type Cache interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	// Set stores entry for ttl, 0 = the backend's default ttl. a zero StoredAt
+	// is set to now
+	Set(ctx context.Context, key string, entry Entry, ttl time.Duration) error
+	// Delete removes key, a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Size is the number of stored entries
+	Size(ctx context.Context) (int64, error)
+	// Name identifies the backend in logs and health output
+	Name() string
+	Close() error
+}
+
+// Entry is a cached response, the model that answered it and when it was
+// stored. Raw is the response's upstream body, restored on Get. ExpiresAt is
+// set by the backend from the ttl, zero if it never expires. Model, Raw and
+// ExpiresAt are empty for entries written before they were recorded
+type Entry struct {
+	Response  *models.GeminiResponse `json:"response"`
+	Raw       json.RawMessage        `json:"raw,omitempty"`
+	Model     string                 `json:"model,omitempty"`
+	StoredAt  time.Time              `json:"stored_at"`
+	ExpiresAt time.Time              `json:"expires_at,omitempty"`
+}
+
+// Age is how long ago the entry was stored, 0 if unknown
+func (e *Entry) Age() time.Duration {
+	if e.StoredAt.IsZero() {
+		return 0
+	}
+	return time.Since(e.StoredAt)
+}
+
+// stamped fills in what Set derives: the store time, the expiry for ttl and
+// the raw body
+func stamped(e Entry, ttl time.Duration) Entry {
+	if e.StoredAt.IsZero() {
+		e.StoredAt = time.Now()
+	}
+	e.ExpiresAt = time.Time{}
+	if ttl > 0 {
+		e.ExpiresAt = time.Now().Add(ttl)
+	}
+	if len(e.Raw) == 0 && e.Response != nil {
+		e.Raw = e.Response.Raw
+	}
+	return e
+}
+
+func decodeEntry(data []byte) (*Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Response == nil {
+		// written before entries carried their store time
+		var resp models.GeminiResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+		entry.Response = &resp
+	}
+	entry.Response.Raw = entry.Raw
+	return &entry, nil
+}
+
+// New builds the cache selected by cache.backend. redis is guarded, so an
+// unreachable redis degrades the cache instead of failing startup
+func New(cfg *config.Config) (Cache, error) {
+	switch cfg.Cache.Backend {
+	case "memory":
+		return newMemoryFromConfig(cfg), nil
+	case "tiered":
+		return NewTieredCache(newMemoryFromConfig(cfg), newGuardedRedis(cfg)), nil
+	case "redis":
+		return newGuardedRedis(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend '%s'", cfg.Cache.Backend)
+	}
+}
+
+func newGuardedRedis(cfg *config.Config) *GuardedCache {
+	return NewGuardedCache("redis", func() (Cache, error) {
+		return NewRedisCache(cfg)
+	}, breaker.DefaultRetryInterval)
+}
+
+func newMemoryFromConfig(cfg *config.Config) *MemoryCache {
+	ttl := cfg.Cache.MemoryTTL
+	if ttl <= 0 {
+		ttl = cfg.Redis.TTL
+	}
+	return NewMemoryCache(cfg.Cache.MaxEntries, time.Duration(ttl)*time.Second)
+}
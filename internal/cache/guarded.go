@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ai-wrap/internal/breaker"
+)
+
+// ErrUnavailable is returned while the backend is down or its breaker is open
+var ErrUnavailable = errors.New("cache unavailable")
+
+// GuardedCache lets the proxy run without its shared cache. calls fail fast
+// with ErrUnavailable, which callers treat as a miss
+type GuardedCache struct {
+	name string
+	dep  *breaker.Dependency[Cache]
+}
+
+var _ Cache = (*GuardedCache)(nil)
+var _ breaker.Reporter = (*GuardedCache)(nil)
+
+func NewGuardedCache(name string, connect func() (Cache, error), retry time.Duration) *GuardedCache {
+	return &GuardedCache{name: name, dep: breaker.NewDependency(name, connect, retry)}
+}
+
+func (c *GuardedCache) Name() string {
+	return c.name
+}
+
+func (c *GuardedCache) Health() breaker.Health {
+	return c.dep.Health()
+}
+
+func (c *GuardedCache) Close() error {
+	if conn, ok := c.dep.Close(); ok {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (c *GuardedCache) Get(ctx context.Context, key string) (*Entry, error) {
+	conn, ok := c.dep.Acquire()
+	if !ok {
+		return nil, ErrUnavailable
+	}
+	entry, err := conn.Get(ctx, key)
+	c.dep.Done(ctx, err)
+	return entry, err
+}
+
+func (c *GuardedCache) Set(ctx context.Context, key string, e Entry, ttl time.Duration) error {
+	conn, ok := c.dep.Acquire()
+	if !ok {
+		return ErrUnavailable
+	}
+	err := conn.Set(ctx, key, e, ttl)
+	c.dep.Done(ctx, err)
+	return err
+}
+
+func (c *GuardedCache) Delete(ctx context.Context, key string) error {
+	conn, ok := c.dep.Acquire()
+	if !ok {
+		return ErrUnavailable
+	}
+	err := conn.Delete(ctx, key)
+	c.dep.Done(ctx, err)
+	return err
+}
+
+func (c *GuardedCache) Size(ctx context.Context) (int64, error) {
+	conn, ok := c.dep.Acquire()
+	if !ok {
+		return 0, ErrUnavailable
+	}
+	size, err := conn.Size(ctx)
+	c.dep.Done(ctx, err)
+	return size, err
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/models"
+)
+
+func testEntry(tokens int) Entry {
+	return Entry{Response: &models.GeminiResponse{UsageMetadata: models.UsageMetadata{TotalTokenCount: tokens}}}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(2, time.Minute)
+
+	c.Set(ctx, "a", testEntry(1), 0)
+	c.Set(ctx, "b", testEntry(2), 0)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", testEntry(3), 0)
+
+	if resp, _ := c.Get(ctx, "b"); resp != nil {
+		t.Error("expected b to be evicted")
+	}
+	if resp, _ := c.Get(ctx, "a"); resp == nil || resp.Response.UsageMetadata.TotalTokenCount != 1 {
+		t.Errorf("expected a to survive, got %+v", resp)
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestMemoryCacheExpires(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(10, time.Millisecond)
+
+	c.Set(ctx, "a", testEntry(1), 0)
+	time.Sleep(5 * time.Millisecond)
+
+	if resp, _ := c.Get(ctx, "a"); resp != nil {
+		t.Error("expected expired entry to miss")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expected expired entry to be dropped, got %d entries", c.Len())
+	}
+}
+
+func TestTieredCacheWarmsLocal(t *testing.T) {
+	ctx := context.Background()
+	local := NewMemoryCache(10, time.Minute)
+	remote := NewMemoryCache(10, time.Minute)
+	c := NewTieredCache(local, remote)
+
+	remote.Set(ctx, "a", testEntry(1), 0)
+	if resp, _ := c.Get(ctx, "a"); resp == nil {
+		t.Fatal("expected remote hit")
+	}
+	if resp, _ := local.Get(ctx, "a"); resp == nil {
+		t.Error("expected remote hit to warm the local tier")
+	}
+
+	c.Set(ctx, "b", testEntry(2), 0)
+	if resp, _ := remote.Get(ctx, "b"); resp == nil {
+		t.Error("expected set to write through to remote")
+	}
+}
+
+func TestTieredCacheWarmsForRemainingTTL(t *testing.T) {
+	ctx := context.Background()
+	local := NewMemoryCache(10, time.Minute)
+	remote := NewMemoryCache(10, time.Minute)
+	c := NewTieredCache(local, remote)
+
+	remote.Set(ctx, "short", testEntry(1), 20*time.Millisecond)
+	remote.Set(ctx, "default", testEntry(2), 0)
+	c.Get(ctx, "short")
+	c.Get(ctx, "default")
+	time.Sleep(30 * time.Millisecond)
+
+	if resp, _ := local.Get(ctx, "short"); resp != nil {
+		t.Error("expected a warmed entry to expire with the remote one")
+	}
+	if resp, _ := local.Get(ctx, "default"); resp == nil {
+		t.Error("expected a warmed entry to keep the local ttl")
+	}
+}
+
+func TestMemoryCacheCustomTTL(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(10, time.Minute)
+
+	c.Set(ctx, "short", testEntry(1), time.Millisecond)
+	c.Set(ctx, "default", testEntry(2), 0)
+	time.Sleep(5 * time.Millisecond)
+
+	if resp, _ := c.Get(ctx, "short"); resp != nil {
+		t.Error("expected entry with a custom ttl to expire")
+	}
+	if resp, _ := c.Get(ctx, "default"); resp == nil {
+		t.Error("expected entry with the default ttl to survive")
+	}
+}
+
+func TestTieredCacheKeepsStoreTime(t *testing.T) {
+	ctx := context.Background()
+	local := NewMemoryCache(10, time.Minute)
+	remote := NewMemoryCache(10, time.Minute)
+	c := NewTieredCache(local, remote)
+
+	remote.Set(ctx, "a", testEntry(1), 0)
+	time.Sleep(5 * time.Millisecond)
+	c.Get(ctx, "a")
+
+	entry, _ := local.Get(ctx, "a")
+	if entry == nil || entry.Age() < 5*time.Millisecond {
+		t.Errorf("expected warmed entry to keep its age, got %+v", entry)
+	}
+}
+
+func TestDecodeLegacyEntry(t *testing.T) {
+	entry, err := decodeEntry([]byte(`{"usageMetadata":{"totalTokenCount":7}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Response.UsageMetadata.TotalTokenCount != 7 || entry.Age() != 0 {
+		t.Errorf("expected legacy response with unknown age, got %+v", entry)
+	}
+}
+
+func TestTieredCacheDeletesBothTiers(t *testing.T) {
+	ctx := context.Background()
+	local := NewMemoryCache(10, time.Minute)
+	remote := NewMemoryCache(10, time.Minute)
+	c := NewTieredCache(local, remote)
+
+	c.Set(ctx, "a", testEntry(1), 0)
+	c.Set(ctx, "b", testEntry(2), 0)
+	if err := c.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "missing"); err != nil {
+		t.Errorf("expected deleting a missing key to succeed, got %v", err)
+	}
+
+	if resp, _ := local.Get(ctx, "a"); resp != nil {
+		t.Error("expected a to be gone from the local tier")
+	}
+	if resp, _ := remote.Get(ctx, "a"); resp != nil {
+		t.Error("expected a to be gone from the remote tier")
+	}
+	if size, _ := c.Size(ctx); size != 1 {
+		t.Errorf("expected 1 entry left, got %d", size)
+	}
+}
+
+func TestMemoryCacheKeepsRawBody(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(10, time.Minute)
+
+	raw := []byte(`{"usageMetadata":{"totalTokenCount":1},"unknownField":true}`)
+	c.Set(ctx, "a", Entry{Response: &models.GeminiResponse{Raw: raw}, Model: "m"}, 0)
+
+	entry, _ := c.Get(ctx, "a")
+	if entry == nil || string(entry.Response.Raw) != string(raw) || entry.Model != "m" {
+		t.Errorf("expected the raw body and model back, got %+v", entry)
+	}
+}
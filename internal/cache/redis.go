@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"ai-wrap/internal/config"
-	"ai-wrap/internal/models"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -17,6 +16,8 @@ type RedisCache struct {
 	ttl    time.Duration
 }
 
+var _ Cache = (*RedisCache)(nil)
+
 func NewRedisCache(cfg *config.Config) (*RedisCache, error) {
 	opt, err := redis.ParseURL(cfg.Redis.URI)
 	if err != nil {
@@ -38,11 +39,15 @@ func NewRedisCache(cfg *config.Config) (*RedisCache, error) {
 	}, nil
 }
 
+func (c *RedisCache) Name() string {
+	return "redis"
+}
+
 func (c *RedisCache) Close() error {
 	return c.client.Close()
 }
 
-func (c *RedisCache) Get(ctx context.Context, key string) (*models.GeminiResponse, error) {
+func (c *RedisCache) Get(ctx context.Context, key string) (*Entry, error) {
 	data, err := c.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
 		return nil, nil
@@ -51,19 +56,26 @@ func (c *RedisCache) Get(ctx context.Context, key string) (*models.GeminiRespons
 		return nil, err
 	}
 
-	var resp models.GeminiResponse
-	if err := json.Unmarshal(data, &resp); err != nil {
-		return nil, err
-	}
-
-	return &resp, nil
+	return decodeEntry(data)
 }
 
-func (c *RedisCache) Set(ctx context.Context, key string, resp *models.GeminiResponse) error {
-	data, err := json.Marshal(resp)
+func (c *RedisCache) Set(ctx context.Context, key string, e Entry, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	data, err := json.Marshal(stamped(e, ttl))
 	if err != nil {
 		return err
 	}
+	return c.client.Set(ctx, key, data, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
 
-	return c.client.Set(ctx, key, data, c.ttl).Err()
+// Size counts every key in the redis db, so it's only exact when the db is
+// dedicated to the cache
+func (c *RedisCache) Size(ctx context.Context) (int64, error) {
+	return c.client.DBSize(ctx).Result()
 }
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ai-wrap/internal/breaker"
+)
+
+// TieredCache puts a local lru in front of a shared cache. hot prompts are
+// served without a network round trip and remote hits warm the local tier
+type TieredCache struct {
+	local  *MemoryCache
+	remote Cache
+}
+
+var _ Cache = (*TieredCache)(nil)
+var _ breaker.Reporter = (*TieredCache)(nil)
+
+func NewTieredCache(local *MemoryCache, remote Cache) *TieredCache {
+	return &TieredCache{local: local, remote: remote}
+}
+
+func (c *TieredCache) Name() string {
+	return "memory+" + c.remote.Name()
+}
+
+// Health reports the shared tier, the local tier can't degrade
+func (c *TieredCache) Health() breaker.Health {
+	health := breaker.Health{Backend: c.Name(), Status: breaker.StatusOK}
+	if r, ok := c.remote.(breaker.Reporter); ok {
+		health = r.Health()
+		health.Backend = c.Name()
+	}
+	return health
+}
+
+func (c *TieredCache) Close() error {
+	c.local.Close()
+	return c.remote.Close()
+}
+
+func (c *TieredCache) Get(ctx context.Context, key string) (*Entry, error) {
+	if entry, _ := c.local.Get(ctx, key); entry != nil {
+		return entry, nil
+	}
+
+	entry, err := c.remote.Get(ctx, key)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if ttl, ok := c.warmTTL(entry); ok {
+		if err := c.local.Set(ctx, key, *entry, ttl); err != nil {
+			log.Printf("failed to warm local cache: %v", err)
+		}
+	}
+	return entry, nil
+}
+
+// warmTTL keeps a warmed entry no longer than the remote one lives, e.g. one
+// stored with a short X-Cache-TTL by another instance. 0 is the local
+// default, false means the entry expires before it could be used
+func (c *TieredCache) warmTTL(entry *Entry) (time.Duration, bool) {
+	if entry.ExpiresAt.IsZero() {
+		return 0, true
+	}
+	remaining := time.Until(entry.ExpiresAt)
+	if remaining <= 0 {
+		return 0, false
+	}
+	if c.local.ttl > 0 && c.local.ttl < remaining {
+		return 0, true
+	}
+	return remaining, true
+}
+
+// Set writes both tiers. the local write always happens, so a remote failure
+// still leaves this instance with a working cache
+func (c *TieredCache) Set(ctx context.Context, key string, e Entry, ttl time.Duration) error {
+	e = stamped(e, ttl)
+	if err := c.local.Set(ctx, key, e, ttl); err != nil {
+		return err
+	}
+	return c.remote.Set(ctx, key, e, ttl)
+}
+
+func (c *TieredCache) Delete(ctx context.Context, key string) error {
+	c.local.Delete(ctx, key)
+	return c.remote.Delete(ctx, key)
+}
+
+// Size is the shared tier's, the local tier only holds a subset of it
+func (c *TieredCache) Size(ctx context.Context) (int64, error) {
+	return c.remote.Size(ctx)
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+
+	"ai-wrap/internal/models"
+)
+
+// withFallback runs do on each model in chain, moving on only when the model is
+// rate limited or failing upstream on every key. other errors (bad request,
+// auth) would fail the same way on any model, so they are returned as-is
+func (c *GeminiClient) withFallback(chain []string, userAPIKey string, do func(model, apiKey string) (models.GeminiResponse, int, error)) (models.GeminiResponse, string, int, error) {
+	if len(chain) == 0 {
+		return models.GeminiResponse{}, "", http.StatusBadRequest, fmt.Errorf("no model to call")
+	}
+
+	var resp models.GeminiResponse
+	var statusCode int
+	var err error
+
+	for i, model := range chain {
+		resp, statusCode, err = c.withKeyRotation(model, userAPIKey, func(apiKey string) (models.GeminiResponse, int, error) {
+			return do(model, apiKey)
+		})
+		if err == nil || !shouldFallback(statusCode) || i == len(chain)-1 {
+			return resp, model, statusCode, err
+		}
+		fmt.Printf("model %s failed with %d on all keys, falling back to %s\n", model, statusCode, chain[i+1])
+	}
+	return resp, "", statusCode, err
+}
+
+func shouldFallback(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/models"
+)
+
+// fakeGemini answers generateContent with the status configured per model
+func fakeGemini(t *testing.T, statuses map[string]int) (*GeminiClient, *[]string) {
+	var called []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		model := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/models/"), ":generateContent")
+		called = append(called, model)
+		status, ok := statuses[model]
+		if !ok {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"candidates":[{"content":{"parts":[{"text":"` + model + `"}]}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &config.Config{Gemini: config.GeminiConfig{APIURL: srv.URL, Timeout: 5}}
+	return NewGeminiClient(config.NewHolder("", cfg), nil), &called
+}
+
+func TestGenerateContentFallsBackOnRateLimit(t *testing.T) {
+	c, called := fakeGemini(t, map[string]int{"primary": http.StatusTooManyRequests, "second": http.StatusServiceUnavailable})
+
+	resp, model, status, err := c.GenerateContent([]string{"primary", "second", "third"}, models.GeminiRequest{}, "user-key")
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("expected success, got %d %v", status, err)
+	}
+	if model != "third" || resp.Candidates[0].Content.Parts[0].Text != "third" {
+		t.Errorf("expected third to answer, got %s", model)
+	}
+	if strings.Join(*called, ",") != "primary,second,third" {
+		t.Errorf("unexpected call order %v", *called)
+	}
+}
+
+func TestGenerateContentKeepsClientErrors(t *testing.T) {
+	c, called := fakeGemini(t, map[string]int{"primary": http.StatusBadRequest})
+
+	_, model, status, err := c.GenerateContent([]string{"primary", "second"}, models.GeminiRequest{}, "user-key")
+	if err == nil || status != http.StatusBadRequest || model != "primary" {
+		t.Fatalf("expected the 400 from primary, got %s %d %v", model, status, err)
+	}
+	if len(*called) != 1 {
+		t.Errorf("expected no fallback on 400, called %v", *called)
+	}
+}
+
+func TestGenerateContentReturnsLastFailure(t *testing.T) {
+	c, _ := fakeGemini(t, map[string]int{"primary": http.StatusTooManyRequests, "second": http.StatusInternalServerError})
+
+	_, model, status, err := c.GenerateContent([]string{"primary", "second"}, models.GeminiRequest{}, "user-key")
+	if err == nil || status != http.StatusInternalServerError || model != "second" {
+		t.Fatalf("expected the 500 from second, got %s %d %v", model, status, err)
+	}
+}
@@ -2,10 +2,13 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"ai-wrap/internal/config"
@@ -13,33 +16,100 @@ import (
 	"ai-wrap/internal/models"
 )
 
+// APIError is a non-200 upstream response
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gemini api returned %d: %s", e.StatusCode, e.Body)
+}
+
+func newAPIError(httpResp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: httpResp.StatusCode,
+		Body:       string(body),
+	}
+	if retryAfter := httpResp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(retryAfter); err == nil {
+			apiErr.RetryAfter = time.Until(at)
+		}
+	}
+	return apiErr
+}
+
 type GeminiClient struct {
-	cfg *config.Config
-	km  *keymanager.KeyManager
+	cfg          *config.Holder
+	km           *keymanager.KeyManager
+	streamClient *http.Client
 }
 
-func NewGeminiClient(cfg *config.Config, km *keymanager.KeyManager) *GeminiClient {
+func NewGeminiClient(cfg *config.Holder, km *keymanager.KeyManager) *GeminiClient {
 	return &GeminiClient{
-		cfg: cfg,
-		km:  km,
+		cfg:          cfg,
+		km:           km,
+		streamClient: &http.Client{},
+	}
+}
+
+// GenerateContent tries each model in chain until one answers, and returns the
+// model that did
+func (c *GeminiClient) GenerateContent(chain []string, req models.GeminiRequest, userAPIKey string) (models.GeminiResponse, string, int, error) {
+	return c.withFallback(chain, userAPIKey, func(model, apiKey string) (models.GeminiResponse, int, error) {
+		return c.call(model, req, apiKey)
+	})
+}
+
+// StreamGenerateContent opens an sse stream upstream. keys and models are rotated
+// until one is accepted, after that the caller owns the returned body and must
+// close it
+func (c *GeminiClient) StreamGenerateContent(chain []string, req models.GeminiRequest, userAPIKey string) (io.ReadCloser, models.GeminiResponse, string, int, error) {
+	var stream io.ReadCloser
+	resp, model, statusCode, err := c.withFallback(chain, userAPIKey, func(model, apiKey string) (models.GeminiResponse, int, error) {
+		body, errResp, statusCode, err := c.openStream(model, req, apiKey)
+		stream = body
+		return errResp, statusCode, err
+	})
+	if err != nil {
+		return nil, resp, model, statusCode, err
 	}
+	return stream, resp, model, statusCode, nil
 }
 
-func (c *GeminiClient) GenerateContent(model string, req models.GeminiRequest, userAPIKey string) (models.GeminiResponse, int, error) {
+func (c *GeminiClient) CountTokens(model string, req models.CountTokensRequest, userAPIKey string) (models.CountTokensResponse, int, error) {
+	var result models.CountTokensResponse
+	_, statusCode, err := c.withKeyRotation(model, userAPIKey, func(apiKey string) (models.GeminiResponse, int, error) {
+		var statusCode int
+		var err error
+		result, statusCode, err = c.countTokens(model, req, apiKey)
+		return models.GeminiResponse{Error: result.Error}, statusCode, err
+	})
+	return result, statusCode, err
+}
+
+func (c *GeminiClient) withKeyRotation(model, userAPIKey string, do func(apiKey string) (models.GeminiResponse, int, error)) (models.GeminiResponse, int, error) {
 	if userAPIKey != "" {
-		return c.call(model, req, userAPIKey)
+		return do(userAPIKey)
 	}
 
-	totalKeys := c.km.ActiveCount()
-	if totalKeys == 0 {
+	if c.km.ActiveCount() == 0 {
 		return models.GeminiResponse{}, http.StatusUnauthorized, fmt.Errorf("no api key provided and no keys available in pool")
 	}
 
-	apiKey := c.km.GetKey()
-	triedKeys := 1
+	apiKey := c.km.GetKey(model)
+	if apiKey == "" {
+		retryIn := time.Until(c.km.NextAvailable()).Round(time.Second)
+		return models.GeminiResponse{}, http.StatusTooManyRequests, fmt.Errorf("all pool keys are cooling down after rate limits, retry in %s", retryIn)
+	}
+	tried := map[string]bool{}
 
 	for {
-		resp, statusCode, err := c.call(model, req, apiKey)
+		tried[apiKey] = true
+		resp, statusCode, err := do(apiKey)
 
 		if err == nil {
 			return resp, statusCode, nil
@@ -49,21 +119,37 @@ func (c *GeminiClient) GenerateContent(model string, req models.GeminiRequest, u
 			return resp, statusCode, err
 		}
 
-		if statusCode == http.StatusForbidden {
+		switch statusCode {
+		case http.StatusForbidden:
 			if markErr := c.km.MarkInactive(apiKey); markErr != nil {
 				fmt.Printf("failed to mark key as inactive: %v\n", markErr)
 			} else {
 				fmt.Printf("marked key as inactive due to 403 response\n")
 			}
+		case http.StatusTooManyRequests:
+			cooldown := retryDelay(resp, err)
+			c.km.Cooldown(apiKey, cooldown)
+			fmt.Printf("key rate limited, cooling down for %s\n", cooldown)
 		}
 
-		if triedKeys >= totalKeys {
+		next := c.km.RotateKey(model, tried)
+		if next == "" {
 			return resp, statusCode, err
 		}
+		apiKey = next
+	}
+}
 
-		apiKey = c.km.RotateKey(apiKey)
-		triedKeys++
+// retryDelay prefers the RetryInfo hint in the error body, then Retry-After
+func retryDelay(resp models.GeminiResponse, err error) time.Duration {
+	if d, ok := resp.Error.RetryDelay(); ok && d > 0 {
+		return d
 	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	return keymanager.DefaultCooldown
 }
 
 func (c *GeminiClient) shouldRetry(statusCode int) bool {
@@ -80,7 +166,7 @@ func (c *GeminiClient) shouldRetry(statusCode int) bool {
 }
 
 func (c *GeminiClient) call(model string, req models.GeminiRequest, apiKey string) (models.GeminiResponse, int, error) {
-	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", c.cfg.Gemini.APIURL, model, apiKey)
+	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", c.cfg.Get().Gemini.APIURL, model, apiKey)
 
 	body, err := json.Marshal(req)
 	if err != nil {
@@ -88,7 +174,7 @@ func (c *GeminiClient) call(model string, req models.GeminiRequest, apiKey strin
 	}
 
 	client := &http.Client{
-		Timeout: time.Duration(c.cfg.Gemini.Timeout) * time.Second,
+		Timeout: time.Duration(c.cfg.Get().Gemini.Timeout) * time.Second,
 	}
 
 	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
@@ -109,13 +195,112 @@ func (c *GeminiClient) call(model string, req models.GeminiRequest, apiKey strin
 	if httpResp.StatusCode != http.StatusOK {
 		var errResp models.GeminiResponse
 		json.Unmarshal(bodyBytes, &errResp)
-		return errResp, httpResp.StatusCode, fmt.Errorf("gemini api returned %d: %s", httpResp.StatusCode, string(bodyBytes))
+		return errResp, httpResp.StatusCode, newAPIError(httpResp, bodyBytes)
 	}
 
 	var resp models.GeminiResponse
 	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
 		return models.GeminiResponse{}, http.StatusInternalServerError, err
 	}
+	resp.Raw = bodyBytes
+
+	return resp, httpResp.StatusCode, nil
+}
+
+func (c *GeminiClient) openStream(model string, req models.GeminiRequest, apiKey string) (io.ReadCloser, models.GeminiResponse, int, error) {
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse&key=%s", c.cfg.Get().Gemini.APIURL, model, apiKey)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, models.GeminiResponse{}, http.StatusInternalServerError, err
+	}
+
+	// streams can outlive the request timeout, so only bound the wait for
+	// headers. the timeout is read per stream so a config reload applies
+	ctx, cancel := context.WithCancel(context.Background())
+	timeout := time.Duration(c.cfg.Get().Gemini.Timeout) * time.Second
+	timer := time.AfterFunc(timeout, cancel)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		cancel()
+		return nil, models.GeminiResponse{}, http.StatusInternalServerError, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := c.streamClient.Do(httpReq)
+	if !timer.Stop() {
+		if err == nil {
+			httpResp.Body.Close()
+		}
+		return nil, models.GeminiResponse{}, http.StatusGatewayTimeout, fmt.Errorf("no response headers from gemini within %s", timeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, models.GeminiResponse{}, http.StatusInternalServerError, err
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		defer cancel()
+		defer httpResp.Body.Close()
+		bodyBytes, _ := io.ReadAll(httpResp.Body)
+
+		var errResp models.GeminiResponse
+		json.Unmarshal(bodyBytes, &errResp)
+		return nil, errResp, httpResp.StatusCode, newAPIError(httpResp, bodyBytes)
+	}
+
+	return &streamBody{ReadCloser: httpResp.Body, cancel: cancel}, models.GeminiResponse{}, httpResp.StatusCode, nil
+}
+
+// streamBody releases the stream's context once the caller closes it
+type streamBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *streamBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func (c *GeminiClient) countTokens(model string, req models.CountTokensRequest, apiKey string) (models.CountTokensResponse, int, error) {
+	url := fmt.Sprintf("%s/models/%s:countTokens?key=%s", c.cfg.Get().Gemini.APIURL, model, apiKey)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return models.CountTokensResponse{}, http.StatusInternalServerError, err
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(c.cfg.Get().Gemini.Timeout) * time.Second,
+	}
+
+	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return models.CountTokensResponse{}, http.StatusInternalServerError, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return models.CountTokensResponse{}, http.StatusInternalServerError, err
+	}
+	defer httpResp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(httpResp.Body)
+
+	var resp models.CountTokensResponse
+	if httpResp.StatusCode != http.StatusOK {
+		json.Unmarshal(bodyBytes, &resp)
+		return resp, httpResp.StatusCode, newAPIError(httpResp, bodyBytes)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
+		return models.CountTokensResponse{}, http.StatusInternalServerError, err
+	}
 
 	return resp, httpResp.StatusCode, nil
 }
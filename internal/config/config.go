@@ -1,121 +1,200 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
-	"strconv"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server  ServerConfig
-	Gemini  GeminiConfig
-	MongoDB MongoDBConfig
-	Redis   RedisConfig
-	Cache   CacheConfig
-	Costs   CostsConfig
+	Server  ServerConfig  `yaml:"server"`
+	Auth    AuthConfig    `yaml:"auth"`
+	Keys    KeysConfig    `yaml:"keys"`
+	Gemini  GeminiConfig  `yaml:"gemini"`
+	Storage StorageConfig `yaml:"storage"`
+	MongoDB MongoDBConfig `yaml:"mongodb"`
+	Redis   RedisConfig   `yaml:"redis"`
+	Cache   CacheConfig   `yaml:"cache"`
+	Costs   CostsConfig   `yaml:"costs"`
+	Routing RoutingConfig `yaml:"routing"`
 }
 
 type ServerConfig struct {
-	Port int
+	Port int `yaml:"port" env:"PORT"`
+}
+
+type AuthConfig struct {
+	// RequireVirtualKey rejects pool requests that don't present a virtual key
+	RequireVirtualKey bool `yaml:"require_virtual_key" env:"AUTH_REQUIRE_VIRTUAL_KEY"`
+	// AdminToken guards /admin, empty disables the admin api
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+}
+
+type KeysConfig struct {
+	// Path is the pool keys csv
+	Path string `yaml:"path" env:"KEYS_PATH"`
+	// CheckInterval is how often (seconds) the pool is probed, 0 disables it
+	CheckInterval int `yaml:"check_interval" env:"KEYS_CHECK_INTERVAL"`
+	// CheckReactivate lets a passing check re-enable an inactive key
+	CheckReactivate bool `yaml:"check_reactivate" env:"KEYS_CHECK_REACTIVATE"`
 }
 
 type GeminiConfig struct {
-	APIURL  string
-	Timeout int
+	// APIURL is the upstream base url, point it at a mock or regional endpoint
+	APIURL string `yaml:"api_url" env:"GEMINI_API_URL"`
+	// Timeout is the upstream request timeout in seconds
+	Timeout int `yaml:"timeout" env:"GEMINI_TIMEOUT"`
+}
+
+type StorageConfig struct {
+	// Backend is "mongodb" (default) or "bolt" for an embedded single-file store
+	Backend string `yaml:"backend" env:"STORAGE_BACKEND"`
+	// Path is the bolt database file
+	Path string `yaml:"path" env:"STORAGE_PATH"`
 }
 
 type MongoDBConfig struct {
-	URI        string
-	Database   string
-	Collection string
+	URI                   string `yaml:"uri" env:"MONGO_URI"`
+	Database              string `yaml:"database" env:"MONGO_DATABASE"`
+	Collection            string `yaml:"collection" env:"MONGO_COLLECTION"`
+	VirtualKeysCollection string `yaml:"virtual_keys_collection" env:"MONGO_VIRTUAL_KEYS_COLLECTION"`
 }
 
 type RedisConfig struct {
-	URI string
-	TTL int
+	URI string `yaml:"uri" env:"REDIS_URI"`
+	// TTL is the cache entry ttl in seconds
+	TTL int `yaml:"ttl" env:"REDIS_TTL"`
 }
 
 type CacheConfig struct {
-	MaxTemp float64 `yaml:"max_temp"`
+	MaxTemp float64 `yaml:"max_temp" env:"CACHE_MAX_TEMP"`
+	// Backend is "redis" (default), "memory" for an in-process lru, or
+	// "tiered" for the lru in front of redis
+	Backend string `yaml:"backend" env:"CACHE_BACKEND"`
+	// MaxEntries bounds the in-process lru
+	MaxEntries int `yaml:"max_entries" env:"CACHE_MAX_ENTRIES"`
+	// MemoryTTL is the lru ttl in seconds, 0 = same as redis
+	MemoryTTL int `yaml:"memory_ttl" env:"CACHE_MEMORY_TTL"`
+	// ModelTTL overrides the ttl (seconds) of entries answered by a model
+	ModelTTL map[string]int `yaml:"model_ttl"`
+	// MaxTTL caps X-Cache-TTL in seconds, 0 = at most the entry's default
+	// ttl, so clients can only shorten it
+	MaxTTL int `yaml:"max_ttl" env:"CACHE_MAX_TTL"`
+	// FallbackMaxAge is the age (seconds) above which logged responses are no
+	// longer served by the store fallback, 0 = no limit
+	FallbackMaxAge int            `yaml:"fallback_max_age" env:"CACHE_FALLBACK_MAX_AGE"`
+	Semantic       SemanticConfig `yaml:"semantic"`
 }
 
-type CostsConfig struct {
-	MaxCost float64       `yaml:"max_cost"`
-	Models  []ModelConfig `yaml:"models"`
+// TTL is how long to cache a response of model, 0 = the backend's default
+func (c CacheConfig) TTL(model string) time.Duration {
+	return time.Duration(c.ModelTTL[model]) * time.Second
 }
 
-type ModelConfig struct {
-	Name   string  `yaml:"name"`
-	Input  float64 `yaml:"input"`
-	Output float64 `yaml:"output"`
+// RequestedTTL caps a ttl asked for with X-Cache-TTL for a response of model
+// at cache.max_ttl, or else at the ttl the entry would get without it
+func (c *Config) RequestedTTL(model string, requested time.Duration) time.Duration {
+	limit := time.Duration(c.Cache.MaxTTL) * time.Second
+	if limit <= 0 {
+		limit = c.Cache.TTL(model)
+	}
+	if limit <= 0 && c.Cache.Backend == "memory" {
+		limit = time.Duration(c.Cache.MemoryTTL) * time.Second
+	}
+	if limit <= 0 {
+		limit = time.Duration(c.Redis.TTL) * time.Second
+	}
+	if limit > 0 && requested > limit {
+		return limit
+	}
+	return requested
 }
 
-type ModelCost struct {
-	Input  float64
-	Output float64
+// FallbackSince is the oldest log the store fallback may serve, zero if any
+func (c CacheConfig) FallbackSince(now time.Time) time.Time {
+	if c.FallbackMaxAge <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(c.FallbackMaxAge) * time.Second)
 }
 
-func getEnv(key, defaultVal string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
-	}
-	return defaultVal
+// SemanticConfig serves near-duplicate prompts from the cache
+type SemanticConfig struct {
+	Enabled bool `yaml:"enabled" env:"CACHE_SEMANTIC_ENABLED"`
+	// Threshold is the minimum fingerprint similarity for a hit, 0 to 1
+	Threshold float64 `yaml:"threshold" env:"CACHE_SEMANTIC_THRESHOLD"`
+	// MaxEntries bounds the in-process fingerprint index
+	MaxEntries int `yaml:"max_entries" env:"CACHE_SEMANTIC_MAX_ENTRIES"`
 }
 
-func getEnvInt(key string, defaultVal int) int {
-	if val := os.Getenv(key); val != "" {
-		if i, err := strconv.Atoi(val); err == nil {
-			return i
-		}
-	}
-	return defaultVal
+type CostsConfig struct {
+	MaxCost     float64       `yaml:"max_cost" env:"COSTS_MAX_COST"`
+	CountTokens bool          `yaml:"count_tokens" env:"COSTS_COUNT_TOKENS"`
+	Models      []ModelConfig `yaml:"models"`
 }
 
-func getEnvFloat(key string, defaultVal float64) float64 {
-	if val := os.Getenv(key); val != "" {
-		if f, err := strconv.ParseFloat(val, 64); err == nil {
-			return f
-		}
+type ModelConfig struct {
+	Name      string `yaml:"name"`
+	ModelCost `yaml:",inline"`
+}
+
+// defaults are what a setting is when neither config.yaml nor the env set it
+func defaults() *Config {
+	return &Config{
+		Server: ServerConfig{Port: 8089},
+		Keys:   KeysConfig{Path: "data/keys.csv"},
+		Gemini: GeminiConfig{
+			APIURL:  "https://generativelanguage.googleapis.com/v1beta",
+			Timeout: 120,
+		},
+		Storage: StorageConfig{Backend: "mongodb", Path: "data/aiwrap.db"},
+		MongoDB: MongoDBConfig{
+			URI:                   "mongodb://localhost:27017",
+			Database:              "aiwrap",
+			Collection:            "requests",
+			VirtualKeysCollection: "virtual_keys",
+		},
+		Redis: RedisConfig{URI: "redis://localhost:6379", TTL: 3600},
+		Cache: CacheConfig{
+			Backend:        "redis",
+			MaxEntries:     10000,
+			FallbackMaxAge: 7 * 24 * 3600,
+			Semantic:       SemanticConfig{Threshold: 0.95, MaxEntries: 10000},
+		},
 	}
-	return defaultVal
 }
 
+// Load layers defaults, then config.yaml, then env vars (each field's env tag)
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
-	var yamlCfg struct {
-		Cache CacheConfig `yaml:"cache"`
-		Costs CostsConfig `yaml:"costs"`
+	cfg := defaults()
+
+	// unknown keys are errors, a typo must not silently fall back to a default
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
 	}
 
-	if err := yaml.Unmarshal(data, &yamlCfg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	if err := applyEnv(cfg); err != nil {
+		return nil, fmt.Errorf("invalid environment:\n%w", err)
 	}
 
-	cfg := &Config{
-		Server: ServerConfig{
-			Port: getEnvInt("PORT", 8089),
-		},
-		Gemini: GeminiConfig{
-			APIURL:  "https://generativelanguage.googleapis.com/v1beta",
-			Timeout: getEnvInt("GEMINI_TIMEOUT", 120),
-		},
-		MongoDB: MongoDBConfig{
-			URI:        getEnv("MONGO_URI", "mongodb://localhost:27017"),
-			Database:   getEnv("MONGO_DATABASE", "aiwrap"),
-			Collection: "requests",
-		},
-		Redis: RedisConfig{
-			URI: getEnv("REDIS_URI", "redis://localhost:6379"),
-			TTL: getEnvInt("REDIS_TTL", 3600),
-		},
-		Cache: yamlCfg.Cache,
-		Costs: yamlCfg.Costs,
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s:\n%w", path, err)
+	}
+
+	for i := range cfg.Costs.Models {
+		cfg.Costs.Models[i].sortTiers()
 	}
 
 	return cfg, nil
@@ -124,11 +203,28 @@ func Load(path string) (*Config, error) {
 func (c *Config) GetModelCost(model string) (ModelCost, bool) {
 	for _, m := range c.Costs.Models {
 		if m.Name == model {
-			return ModelCost{
-				Input:  m.Input,
-				Output: m.Output,
-			}, true
+			return m.ModelCost, true
 		}
 	}
 	return ModelCost{}, false
 }
+
+// Masked returns a copy safe to print, with credentials in connection uris
+// and the admin token replaced
+func (c *Config) Masked() *Config {
+	masked := *c
+	if c.Auth.AdminToken != "" {
+		masked.Auth.AdminToken = "[redacted]"
+	}
+	masked.MongoDB.URI = maskURI(c.MongoDB.URI)
+	masked.Redis.URI = maskURI(c.Redis.URI)
+	return &masked
+}
+
+func maskURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "[unparseable uri]"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// applyEnv overrides every field that has an env tag and whose variable is
+// set. a value that doesn't parse is an error rather than silently ignored
+func applyEnv(cfg *Config) error {
+	var errs []error
+	walkEnv(reflect.ValueOf(cfg).Elem(), func(name string, field reflect.Value) {
+		val, ok := os.LookupEnv(name)
+		if !ok || val == "" {
+			return
+		}
+		if err := setField(field, val); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// EnvVars lists the env overrides as "NAME=yaml.path", in field order
+func EnvVars() []string {
+	var vars []string
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			path := prefix + yamlName(f)
+			if name := f.Tag.Get("env"); name != "" {
+				vars = append(vars, name+"="+path)
+			} else if f.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), path+".")
+			}
+		}
+	}
+	walk(reflect.ValueOf(Config{}), "")
+	return vars
+}
+
+func walkEnv(v reflect.Value, fn func(name string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Tag.Get("env"); name != "" {
+			fn(name, v.Field(i))
+		} else if v.Field(i).Kind() == reflect.Struct {
+			walkEnv(v.Field(i), fn)
+		}
+	}
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+func setField(field reflect.Value, val string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+	case reflect.Int:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got '%s'", val)
+		}
+		field.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got '%s'", val)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got '%s'", val)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Kind())
+	}
+	return nil
+}
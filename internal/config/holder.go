@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Holder hands out the current config. Reload swaps in a new one atomically,
+// so a request that already took a snapshot keeps using it until it finishes
+type Holder struct {
+	path    string
+	current atomic.Pointer[Config]
+	mu      sync.Mutex // serializes reloads
+}
+
+func NewHolder(path string, cfg *Config) *Holder {
+	h := &Holder{path: path}
+	h.current.Store(cfg)
+	return h
+}
+
+func (h *Holder) Get() *Config {
+	return h.current.Load()
+}
+
+// Reload reads the file again. an invalid file leaves the current config in
+// place. settings that are only read at startup are applied anyway but
+// logged, since they need a restart to take effect
+func (h *Holder) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cfg, err := Load(h.path)
+	if err != nil {
+		return fmt.Errorf("keeping current config: %w", err)
+	}
+
+	old := h.Get()
+	for _, name := range restartOnly(old, cfg) {
+		log.Printf("warning: %s changed, restart to apply it", name)
+	}
+
+	h.current.Store(cfg)
+	log.Printf("reloaded %s: %d models", h.path, len(cfg.Costs.Models))
+	return nil
+}
+
+// restartOnly names the changed sections that are only read at startup
+func restartOnly(old, cfg *Config) []string {
+	var changed []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	check("server", old.Server, cfg.Server)
+	check("storage", old.Storage, cfg.Storage)
+	check("mongodb", old.MongoDB, cfg.MongoDB)
+	check("redis", old.Redis, cfg.Redis)
+	check("cache.backend", old.Cache.Backend, cfg.Cache.Backend)
+	check("cache.max_entries", old.Cache.MaxEntries, cfg.Cache.MaxEntries)
+	check("cache.memory_ttl", old.Cache.MemoryTTL, cfg.Cache.MemoryTTL)
+	check("cache.semantic.max_entries", old.Cache.Semantic.MaxEntries, cfg.Cache.Semantic.MaxEntries)
+	check("keys.path", old.Keys.Path, cfg.Keys.Path)
+	check("keys.check_interval", old.Keys.CheckInterval, cfg.Keys.CheckInterval)
+	check("keys.check_reactivate", old.Keys.CheckReactivate, cfg.Keys.CheckReactivate)
+	return changed
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// Rates are usd per 1M tokens. a zero rate means "not set" and falls back to
+// the next less specific level
+type Rates struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+	// CachedInput is the rate for context cache hits, defaults to input
+	CachedInput float64 `yaml:"cached_input"`
+}
+
+// PriceTier applies once the prompt is longer than Above tokens
+type PriceTier struct {
+	Above      int `yaml:"above"`
+	Rates      `yaml:",inline"`
+	Modalities map[string]Rates `yaml:"modalities,omitempty"`
+}
+
+// ModelCost is a model's pricing: base rates, per-modality overrides (keyed by
+// lowercase gemini modality: text, image, audio, video, document) and prompt
+// length tiers. thoughts are billed as text output
+type ModelCost struct {
+	Rates      `yaml:",inline"`
+	Modalities map[string]Rates `yaml:"modalities,omitempty"`
+	Tiers      []PriceTier      `yaml:"tiers,omitempty"`
+}
+
+func (m ModelCost) sortTiers() {
+	sort.Slice(m.Tiers, func(i, j int) bool {
+		return m.Tiers[i].Above < m.Tiers[j].Above
+	})
+}
+
+// RatesFor resolves the rates for a modality at a prompt length. the matching
+// tier overrides the base rates, and a modality override beats both, with the
+// tier's modality override winning over the base one
+func (m ModelCost) RatesFor(promptTokens int, modality string) Rates {
+	modality = strings.ToLower(modality)
+
+	rates := m.Rates
+	modalities := []map[string]Rates{m.Modalities}
+	for _, tier := range m.Tiers {
+		if promptTokens <= tier.Above {
+			break
+		}
+		rates = rates.override(tier.Rates)
+		modalities = append(modalities, tier.Modalities)
+	}
+	for _, byModality := range modalities {
+		if r, ok := byModality[modality]; ok {
+			rates = rates.override(r)
+		}
+	}
+
+	if rates.CachedInput == 0 {
+		rates.CachedInput = rates.Input
+	}
+	return rates
+}
+
+func (r Rates) override(with Rates) Rates {
+	if with.Input != 0 {
+		r.Input = with.Input
+	}
+	if with.Output != 0 {
+		r.Output = with.Output
+	}
+	if with.CachedInput != 0 {
+		r.CachedInput = with.CachedInput
+	}
+	return r
+}
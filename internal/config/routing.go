@@ -0,0 +1,32 @@
+package config
+
+// RoutingConfig maps the model names clients send onto configured models
+type RoutingConfig struct {
+	// Aliases map a client-facing name (e.g. "fast") to a model in costs.models
+	Aliases map[string]string `yaml:"aliases"`
+	// Fallbacks list the models tried in order when a model is rate limited or
+	// failing on every key
+	Fallbacks map[string][]string `yaml:"fallbacks"`
+}
+
+// ResolveModel turns a requested name into a configured model, following an
+// alias if there is one
+func (c *Config) ResolveModel(name string) (string, bool) {
+	if target, ok := c.Routing.Aliases[name]; ok {
+		name = target
+	}
+	_, exists := c.GetModelCost(name)
+	return name, exists
+}
+
+// ResolvedName is ResolveModel without the check, for names logged earlier
+// that may no longer be configured
+func (c *Config) ResolvedName(name string) string {
+	model, _ := c.ResolveModel(name)
+	return model
+}
+
+// ModelChain is the model followed by its fallbacks
+func (c *Config) ModelChain(model string) []string {
+	return append([]string{model}, c.Routing.Fallbacks[model]...)
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+)
+
+// modalities are the keys allowed under costs.models[].modalities
+var modalities = []string{"text", "image", "audio", "video", "document"}
+
+// Validate checks the merged config (yaml + env) and reports every problem at
+// once, each prefixed with the yaml path it refers to
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(path, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		fail("server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Gemini.Timeout <= 0 {
+		fail("gemini.timeout", "must be positive, got %d", c.Gemini.Timeout)
+	}
+	if u, err := url.Parse(c.Gemini.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		fail("gemini.api_url", "must be an absolute url, got '%s'", c.Gemini.APIURL)
+	}
+	if c.Keys.Path == "" {
+		fail("keys.path", "required")
+	}
+	if c.Keys.CheckInterval < 0 {
+		fail("keys.check_interval", "must not be negative, got %d", c.Keys.CheckInterval)
+	}
+	if !slices.Contains([]string{"mongodb", "bolt"}, c.Storage.Backend) {
+		fail("storage.backend", "unknown backend '%s', expected mongodb or bolt", c.Storage.Backend)
+	}
+	if c.Storage.Backend == "bolt" && c.Storage.Path == "" {
+		fail("storage.path", "required for the bolt backend")
+	}
+	if c.Storage.Backend == "mongodb" {
+		if c.MongoDB.URI == "" {
+			fail("mongodb.uri", "required for the mongodb backend")
+		}
+		if c.MongoDB.Database == "" {
+			fail("mongodb.database", "required for the mongodb backend")
+		}
+		if c.MongoDB.Collection == "" {
+			fail("mongodb.collection", "required for the mongodb backend")
+		}
+		if c.MongoDB.VirtualKeysCollection == "" {
+			fail("mongodb.virtual_keys_collection", "required for the mongodb backend")
+		}
+	}
+	if c.Cache.Backend != "memory" && c.Redis.URI == "" {
+		fail("redis.uri", "required for the %s cache backend", c.Cache.Backend)
+	}
+	if c.Redis.TTL < 0 {
+		fail("redis.ttl", "must not be negative, got %d", c.Redis.TTL)
+	}
+	if !slices.Contains([]string{"redis", "memory", "tiered"}, c.Cache.Backend) {
+		fail("cache.backend", "unknown backend '%s', expected redis, memory or tiered", c.Cache.Backend)
+	}
+	if c.Cache.MaxTemp < 0 || c.Cache.MaxTemp > 2 {
+		fail("cache.max_temp", "must be between 0 and 2 (gemini's temperature range), got %g", c.Cache.MaxTemp)
+	}
+	if c.Cache.MaxEntries < 0 {
+		fail("cache.max_entries", "must not be negative, got %d", c.Cache.MaxEntries)
+	}
+	if c.Cache.MemoryTTL < 0 {
+		fail("cache.memory_ttl", "must not be negative, got %d", c.Cache.MemoryTTL)
+	}
+	if c.Cache.MaxTTL < 0 {
+		fail("cache.max_ttl", "must not be negative, got %d", c.Cache.MaxTTL)
+	}
+	if c.Cache.FallbackMaxAge < 0 {
+		fail("cache.fallback_max_age", "must not be negative, got %d", c.Cache.FallbackMaxAge)
+	}
+	for _, model := range slices.Sorted(maps.Keys(c.Cache.ModelTTL)) {
+		path := "cache.model_ttl." + model
+		if _, exists := c.GetModelCost(model); !exists {
+			fail(path, "unknown model '%s'", model)
+		}
+		if ttl := c.Cache.ModelTTL[model]; ttl <= 0 {
+			fail(path, "must be positive, got %d", ttl)
+		}
+	}
+	if c.Cache.Semantic.Threshold <= 0 || c.Cache.Semantic.Threshold > 1 {
+		fail("cache.semantic.threshold", "must be above 0 and at most 1, got %g", c.Cache.Semantic.Threshold)
+	}
+	if c.Cache.Semantic.MaxEntries < 0 {
+		fail("cache.semantic.max_entries", "must not be negative, got %d", c.Cache.Semantic.MaxEntries)
+	}
+	if c.Costs.MaxCost < 0 {
+		fail("costs.max_cost", "must not be negative, got %g", c.Costs.MaxCost)
+	}
+
+	if len(c.Costs.Models) == 0 {
+		fail("costs.models", "at least one model is required")
+	}
+	seen := map[string]int{}
+	for i, m := range c.Costs.Models {
+		path := fmt.Sprintf("costs.models[%d]", i)
+		if m.Name == "" {
+			fail(path+".name", "required")
+		} else if first, dup := seen[m.Name]; dup {
+			fail(path+".name", "duplicate model '%s', already defined at costs.models[%d]", m.Name, first)
+		} else {
+			seen[m.Name] = i
+		}
+		errs = append(errs, m.ModelCost.validate(path)...)
+	}
+
+	errs = append(errs, c.Routing.validate(c)...)
+
+	return errors.Join(errs...)
+}
+
+func (r RoutingConfig) validate(c *Config) []error {
+	var errs []error
+	isModel := func(name string) bool {
+		_, exists := c.GetModelCost(name)
+		return exists
+	}
+
+	for _, alias := range slices.Sorted(maps.Keys(r.Aliases)) {
+		path := "routing.aliases." + alias
+		if isModel(alias) {
+			errs = append(errs, fmt.Errorf("%s: shadows the model of the same name", path))
+		}
+		if target := r.Aliases[alias]; !isModel(target) {
+			errs = append(errs, fmt.Errorf("%s: unknown model '%s', aliases must point at costs.models", path, target))
+		}
+	}
+
+	for _, model := range slices.Sorted(maps.Keys(r.Fallbacks)) {
+		path := "routing.fallbacks." + model
+		if !isModel(model) {
+			errs = append(errs, fmt.Errorf("%s: unknown model '%s'", path, model))
+		}
+		seen := map[string]bool{model: true}
+		for i, fallback := range r.Fallbacks[model] {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case !isModel(fallback):
+				errs = append(errs, fmt.Errorf("%s: unknown model '%s'", itemPath, fallback))
+			case seen[fallback]:
+				errs = append(errs, fmt.Errorf("%s: '%s' is already in the chain", itemPath, fallback))
+			}
+			seen[fallback] = true
+		}
+	}
+	return errs
+}
+
+func (m ModelCost) validate(path string) []error {
+	errs := m.Rates.validate(path)
+	errs = append(errs, validateModalities(path, m.Modalities)...)
+
+	tiers := map[int]bool{}
+	for i, tier := range m.Tiers {
+		tierPath := fmt.Sprintf("%s.tiers[%d]", path, i)
+		if tier.Above <= 0 {
+			errs = append(errs, fmt.Errorf("%s.above: must be positive, got %d", tierPath, tier.Above))
+		} else if tiers[tier.Above] {
+			errs = append(errs, fmt.Errorf("%s.above: duplicate tier at %d tokens", tierPath, tier.Above))
+		}
+		tiers[tier.Above] = true
+		errs = append(errs, tier.Rates.validate(tierPath)...)
+		errs = append(errs, validateModalities(tierPath, tier.Modalities)...)
+	}
+	return errs
+}
+
+func validateModalities(path string, byModality map[string]Rates) []error {
+	var errs []error
+	keys := slices.Sorted(maps.Keys(byModality))
+	for _, modality := range keys {
+		rates := byModality[modality]
+		modalityPath := fmt.Sprintf("%s.modalities.%s", path, modality)
+		if !slices.Contains(modalities, modality) {
+			errs = append(errs, fmt.Errorf("%s: unknown modality, expected one of %v", modalityPath, modalities))
+		}
+		errs = append(errs, rates.validate(modalityPath)...)
+	}
+	return errs
+}
+
+func (r Rates) validate(path string) []error {
+	var errs []error
+	check := func(name string, rate float64) {
+		if rate < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s: must not be negative, got %g", path, name, rate))
+		}
+	}
+	check("input", r.Input)
+	check("output", r.Output)
+	check("cached_input", r.CachedInput)
+	return errs
+}
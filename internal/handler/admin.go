@@ -2,32 +2,58 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"ai-wrap/internal/cache"
+	"ai-wrap/internal/keymanager"
 	"ai-wrap/internal/models"
+	"ai-wrap/internal/semantic"
 	"ai-wrap/internal/store"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type AdminHandler struct {
-	store *store.MongoStore
+	store store.Store
+	cache cache.Cache
+	// semantic is the proxy's index, purged hashes are dropped from it
+	semantic *semantic.Index
+	km       *keymanager.KeyManager
+	checker  *keymanager.Checker
 }
 
-func NewAdminHandler(store *store.MongoStore) *AdminHandler {
-	return &AdminHandler{store: store}
+func NewAdminHandler(store store.Store, responseCache cache.Cache, index *semantic.Index, km *keymanager.KeyManager, checker *keymanager.Checker) *AdminHandler {
+	return &AdminHandler{
+		store:    store,
+		cache:    responseCache,
+		semantic: index,
+		km:       km,
+		checker:  checker,
+	}
 }
 
 type Stats struct {
-	TotalRequests   int64   `json:"total_requests"`
-	SuccessfulReqs  int64   `json:"successful_requests"`
-	FailedReqs      int64   `json:"failed_requests"`
-	CacheHits       int64   `json:"cache_hits"`
-	TotalCost       float64 `json:"total_cost"`
-	AvgResponseTime int64   `json:"avg_response_time_ms"`
+	TotalRequests   int64             `json:"total_requests"`
+	SuccessfulReqs  int64             `json:"successful_requests"`
+	FailedReqs      int64             `json:"failed_requests"`
+	CacheHits       int64             `json:"cache_hits"`
+	TotalCost       float64           `json:"total_cost"`
+	TotalSpent      float64           `json:"total_spent"`
+	TotalSaved      float64           `json:"total_saved"`
+	AvgResponseTime int64             `json:"avg_response_time_ms"`
+	ByVirtualKey    []VirtualKeyStats `json:"by_virtual_key"`
+}
+
+type VirtualKeyStats struct {
+	VirtualKeyID string  `json:"virtual_key_id"`
+	Name         string  `json:"name"`
+	Team         string  `json:"team"`
+	Project      string  `json:"project"`
+	Requests     int64   `json:"requests"`
+	Cost         float64 `json:"cost"`
 }
 
 type RequestsResponse struct {
@@ -43,42 +69,18 @@ func (h *AdminHandler) GetStats(c *gin.Context) {
 	defer cancel()
 
 	duration := c.DefaultQuery("duration", "24h")
-	filter := h.getTimeFilter(duration)
-
-	collection := h.store.GetCollection()
-
-	// single aggregation for all stats
-	pipeline := []bson.M{
-		{"$match": filter},
-		{"$group": bson.M{
-			"_id":               nil,
-			"total":             bson.M{"$sum": 1},
-			"successful":        bson.M{"$sum": bson.M{"$cond": []interface{}{"$success", 1, 0}}},
-			"failed":            bson.M{"$sum": bson.M{"$cond": []interface{}{"$success", 0, 1}}},
-			"cache_hits":        bson.M{"$sum": bson.M{"$cond": []interface{}{"$cache_hit", 1, 0}}},
-			"total_cost":        bson.M{"$sum": "$cost.total"},
-			"avg_response_time": bson.M{"$avg": "$duration_ms"},
-		}},
-	}
+	since := h.getSince(duration)
 
-	cursor, err := collection.Aggregate(ctx, pipeline)
+	result, err := h.store.GetStats(ctx, since)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var result struct {
-		Total           int64   `bson:"total"`
-		Successful      int64   `bson:"successful"`
-		Failed          int64   `bson:"failed"`
-		CacheHits       int64   `bson:"cache_hits"`
-		TotalCost       float64 `bson:"total_cost"`
-		AvgResponseTime float64 `bson:"avg_response_time"`
-	}
 
-	if cursor.Next(ctx) {
-		cursor.Decode(&result)
+	byVirtualKey, err := h.getVirtualKeyStats(ctx, since)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
 	stats := Stats{
@@ -87,12 +89,48 @@ func (h *AdminHandler) GetStats(c *gin.Context) {
 		FailedReqs:      result.Failed,
 		CacheHits:       result.CacheHits,
 		TotalCost:       result.TotalCost,
-		AvgResponseTime: int64(result.AvgResponseTime),
+		TotalSpent:      result.TotalSpent,
+		TotalSaved:      result.TotalSaved,
+		AvgResponseTime: int64(result.AvgDurationMs),
+		ByVirtualKey:    byVirtualKey,
 	}
 
 	c.JSON(http.StatusOK, stats)
 }
 
+// getVirtualKeyStats breaks usage down per virtual key, labelled with the key's
+// current name/team/project
+func (h *AdminHandler) getVirtualKeyStats(ctx context.Context, since time.Time) ([]VirtualKeyStats, error) {
+	usage, err := h.store.GetVirtualKeyUsage(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := h.store.ListVirtualKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]store.VirtualKey, len(keys))
+	for _, key := range keys {
+		byID[key.ID] = key
+	}
+
+	results := make([]VirtualKeyStats, 0, len(usage))
+	for _, u := range usage {
+		key := byID[u.VirtualKeyID]
+		results = append(results, VirtualKeyStats{
+			VirtualKeyID: u.VirtualKeyID,
+			Name:         key.Name,
+			Team:         key.Team,
+			Project:      key.Project,
+			Requests:     u.Requests,
+			Cost:         u.Cost,
+		})
+	}
+
+	return results, nil
+}
+
 func (h *AdminHandler) GetRequests(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -107,12 +145,9 @@ func (h *AdminHandler) GetRequests(c *gin.Context) {
 		perPage = 20
 	}
 
-	collection := h.store.GetCollection()
-
-	// use estimated count (fast, uses collection metadata)
-	total, err := collection.EstimatedDocumentCount(ctx)
+	total, err := h.store.Count(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -123,7 +158,7 @@ func (h *AdminHandler) GetRequests(c *gin.Context) {
 	// get paginated requests (projection excludes request/response bodies)
 	requests, err := h.store.FindPaginated(ctx, skip, perPage)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -150,6 +185,10 @@ func (h *AdminHandler) GetRequest(c *gin.Context) {
 
 	log, err := h.store.FindByID(ctx, id)
 	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if log == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
@@ -158,34 +197,41 @@ func (h *AdminHandler) GetRequest(c *gin.Context) {
 	c.JSON(http.StatusOK, log)
 }
 
+// redactImageData blanks inline data in every part of the logged request
 func (h *AdminHandler) redactImageData(log *store.RequestLog) {
 	for i := range log.Request.Contents {
-		for j := range log.Request.Contents[i].Parts {
-			part := &log.Request.Contents[i].Parts[j]
-			if part.InlineData != nil {
-				part.InlineData = &models.InlineData{
-					MimeType: part.InlineData.MimeType,
-					Data:     "[redacted]",
-				}
+		redactParts(log.Request.Contents[i].Parts)
+	}
+	if log.Request.SystemInstruction != nil {
+		redactParts(log.Request.SystemInstruction.Parts)
+	}
+}
+
+func redactParts(parts []models.Part) {
+	for i := range parts {
+		if parts[i].InlineData != nil {
+			parts[i].InlineData = &models.InlineData{
+				MimeType: parts[i].InlineData.MimeType,
+				Data:     "[redacted]",
 			}
 		}
 	}
 }
 
-func (h *AdminHandler) getTimeFilter(duration string) bson.M {
-	var since time.Time
+func (h *AdminHandler) getSince(duration string) time.Time {
 	switch duration {
 	case "7d":
-		since = time.Now().Add(-7 * 24 * time.Hour)
+		return time.Now().Add(-7 * 24 * time.Hour)
 	default:
-		since = time.Now().Add(-24 * time.Hour)
+		return time.Now().Add(-24 * time.Hour)
 	}
-	return bson.M{"timestamp": bson.M{"$gte": since}}
 }
 
 type TimeSeriesData struct {
-	Timestamp string `json:"timestamp"`
-	Count     int    `json:"count"`
+	Timestamp string  `json:"timestamp"`
+	Count     int     `json:"count"`
+	Spent     float64 `json:"spent"`
+	Saved     float64 `json:"saved"`
 }
 
 func (h *AdminHandler) GetTimeSeries(c *gin.Context) {
@@ -193,50 +239,38 @@ func (h *AdminHandler) GetTimeSeries(c *gin.Context) {
 	defer cancel()
 
 	duration := c.DefaultQuery("duration", "24h")
-	filter := h.getTimeFilter(duration)
 
-	var groupBy string
+	interval := store.IntervalHour
 	if duration == "7d" {
-		groupBy = "%Y-%m-%d"
-	} else {
-		groupBy = "%Y-%m-%d %H:00"
-	}
-
-	collection := h.store.GetCollection()
-
-	pipeline := []bson.M{
-		{"$match": filter},
-		{"$group": bson.M{
-			"_id":   bson.M{"$dateToString": bson.M{"format": groupBy, "date": "$timestamp"}},
-			"count": bson.M{"$sum": 1},
-		}},
-		{"$sort": bson.M{"_id": 1}},
+		interval = store.IntervalDay
 	}
 
-	cursor, err := collection.Aggregate(ctx, pipeline)
+	results, err := h.store.GetTimeSeries(ctx, h.getSince(duration), interval)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer cursor.Close(ctx)
-
-	var results []struct {
-		ID    string `bson:"_id"`
-		Count int    `bson:"count"`
-	}
-
-	if err := cursor.All(ctx, &results); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	data := make([]TimeSeriesData, len(results))
 	for i, r := range results {
 		data[i] = TimeSeriesData{
-			Timestamp: r.ID,
+			Timestamp: r.Bucket,
 			Count:     r.Count,
+			Spent:     r.Spent,
+			Saved:     r.Saved,
 		}
 	}
 
 	c.JSON(http.StatusOK, data)
 }
+
+// storeErrorStatus maps a degraded store to 503 so clients know to retry
+func storeErrorStatus(err error) int {
+	if errors.Is(err, store.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"testing"
+
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/store"
+)
+
+func TestRedactImageData(t *testing.T) {
+	image := func() []models.Part {
+		return []models.Part{{Text: "look"}, {InlineData: &models.InlineData{MimeType: "image/png", Data: "aGVsbG8="}}}
+	}
+	log := &store.RequestLog{Request: models.GeminiRequest{
+		Contents:          []models.Content{{Parts: image()}, {Parts: image()}},
+		SystemInstruction: &models.Content{Parts: image()},
+	}}
+
+	(&AdminHandler{}).redactImageData(log)
+
+	lists := [][]models.Part{log.Request.Contents[0].Parts, log.Request.Contents[1].Parts, log.Request.SystemInstruction.Parts}
+	for i, parts := range lists {
+		if parts[1].InlineData.Data != "[redacted]" || parts[1].InlineData.MimeType != "image/png" {
+			t.Errorf("part list %d: expected inline data redacted, got %+v", i, parts[1].InlineData)
+		}
+		if parts[0].Text != "look" {
+			t.Errorf("part list %d: expected text untouched", i)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"ai-wrap/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth requires auth.admin_token on every admin route, as a bearer token
+// or X-Admin-Token. the token is read per request, so a reload applies. without
+// a token the admin api is disabled
+func AdminAuth(cfg *config.Holder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := cfg.Get().Auth.AdminToken
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin api disabled, set auth.admin_token"})
+			return
+		}
+
+		given := c.GetHeader("X-Admin-Token")
+		if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && given == "" {
+			given = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ai-wrap/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(token string) *gin.Engine {
+		holder := config.NewHolder("", &config.Config{Auth: config.AuthConfig{AdminToken: token}})
+		r := gin.New()
+		admin := r.Group("/admin", AdminAuth(holder))
+		admin.GET("/stats", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		headers map[string]string
+		want    int
+	}{
+		{"disabled without a token", "", map[string]string{"X-Admin-Token": ""}, http.StatusForbidden},
+		{"missing token", "s3cret", nil, http.StatusUnauthorized},
+		{"wrong token", "s3cret", map[string]string{"X-Admin-Token": "nope"}, http.StatusUnauthorized},
+		{"bearer token", "s3cret", map[string]string{"Authorization": "Bearer s3cret"}, http.StatusOK},
+		{"admin token header", "s3cret", map[string]string{"X-Admin-Token": "s3cret"}, http.StatusOK},
+		{"token without bearer", "s3cret", map[string]string{"Authorization": "s3cret"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			newRouter(tt.token).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
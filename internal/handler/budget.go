@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"sync"
+)
+
+// budgets holds the predicted cost of calls still in flight per virtual key.
+// a budget check counts them on top of the logged spend, so concurrent calls
+// can't all pass against the same spend. a reservation ends once the call's
+// log is stored and the logged spend takes over. the zero value is ready
+type budgets struct {
+	mu   sync.Mutex
+	keys map[string]*keyBudget
+}
+
+type keyBudget struct {
+	// check serializes the spend reads and reservations of one key
+	check    sync.Mutex
+	reserved float64 // guarded by budgets.mu
+	refs     int     // guarded by budgets.mu
+}
+
+// reservation is a call's share of its key's reserved budget, nil for calls
+// without a budget
+type reservation struct {
+	budgets *budgets
+	id      string
+	kb      *keyBudget
+	amount  float64
+}
+
+// lock waits for other checks of the key and returns its state, locked. it
+// must be followed by unlock
+func (b *budgets) lock(id string) *keyBudget {
+	b.mu.Lock()
+	if b.keys == nil {
+		b.keys = make(map[string]*keyBudget)
+	}
+	kb := b.keys[id]
+	if kb == nil {
+		kb = &keyBudget{}
+		b.keys[id] = kb
+	}
+	kb.refs++
+	b.mu.Unlock()
+
+	kb.check.Lock()
+	return kb
+}
+
+// unlock ends a check. with amount > 0 the check passed and the amount stays
+// reserved until the returned reservation is released
+func (b *budgets) unlock(id string, kb *keyBudget, amount float64) *reservation {
+	defer kb.check.Unlock()
+	if amount <= 0 {
+		b.drop(id, kb, 0)
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kb.reserved += amount
+	return &reservation{budgets: b, id: id, kb: kb, amount: amount}
+}
+
+func (b *budgets) reserved(kb *keyBudget) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return kb.reserved
+}
+
+func (b *budgets) drop(id string, kb *keyBudget, amount float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kb.reserved -= amount
+	if kb.refs--; kb.refs == 0 {
+		delete(b.keys, id)
+	}
+}
+
+// release returns the reserved amount, once the call's spend is logged
+func (r *reservation) release() {
+	if r == nil {
+		return
+	}
+	r.budgets.drop(r.id, r.kb, r.amount)
+}
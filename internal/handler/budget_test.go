@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckBudgetIgnoresCacheHits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+
+	for _, l := range []*store.RequestLog{
+		{Timestamp: time.Now(), Model: "m", Success: true, VirtualKeyID: "vk_1", Cost: models.Cost{Total: 0.6}, Spent: 0.6},
+		{Timestamp: time.Now(), Model: "m", Success: true, VirtualKeyID: "vk_1", CacheHit: true, Cost: models.Cost{Total: 0.6}, Saved: 0.6},
+		{Timestamp: time.Now(), Model: "m", Success: true, VirtualKeyID: "vk_1", CacheHit: true, Cost: models.Cost{Total: 0.6}}, // legacy hit
+	} {
+		if err := logStore.LogRequest(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &ProxyHandler{store: logStore}
+	vk := &store.VirtualKey{ID: "vk_1", DailyBudget: 1}
+
+	var held []*reservation
+	check := func(predicted float64) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		if r, ok := h.checkBudget(c, vk, predicted, geminiFormat{}); ok {
+			held = append(held, r)
+			return http.StatusOK
+		}
+		return w.Code
+	}
+
+	if code := check(0.3); code != http.StatusOK {
+		t.Errorf("expected cache hits not to count against the budget, got %d", code)
+	}
+	if code := check(0.3); code != http.StatusPaymentRequired {
+		t.Errorf("expected the call in flight to count against the budget, got %d", code)
+	}
+	for _, r := range held {
+		r.release()
+	}
+	if code := check(0.3); code != http.StatusOK {
+		t.Errorf("expected a released reservation to free the budget, got %d", code)
+	}
+	if code := check(0.5); code != http.StatusPaymentRequired {
+		t.Errorf("expected spend past the budget to be refused, got %d", code)
+	}
+}
+
+func TestCheckBudgetReservesConcurrentCalls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+
+	h := &ProxyHandler{store: logStore}
+	vk := &store.VirtualKey{ID: "vk_1", MonthlyBudget: 1}
+
+	var mu sync.Mutex
+	passed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+			if _, ok := h.checkBudget(c, vk, 0.25, geminiFormat{}); ok {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if passed != 4 {
+		t.Errorf("expected 4 calls of $0.25 to fit a $1 budget, %d passed", passed)
+	}
+}
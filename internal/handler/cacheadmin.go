@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"ai-wrap/internal/cache"
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CacheStats struct {
+	Backend  string  `json:"backend"`
+	Entries  *int64  `json:"entries"` // nil while the cache is unavailable
+	Requests int64   `json:"requests"`
+	Hits     int64   `json:"hits"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// CacheEntryInfo is what each tier holds for a request hash
+type CacheEntryInfo struct {
+	Hash       string                 `json:"hash"`
+	Cached     bool                   `json:"cached"`
+	Model      string                 `json:"model,omitempty"`
+	StoredAt   *time.Time             `json:"stored_at,omitempty"`
+	AgeSeconds int64                  `json:"age_seconds,omitempty"`
+	Response   *models.GeminiResponse `json:"response,omitempty"`
+	// Log is the newest log the store fallback can serve, whatever its age
+	Log *store.RequestLog `json:"log,omitempty"`
+}
+
+type cachePurgeRequest struct {
+	Model string    `json:"model"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+type cacheableRequest struct {
+	Cacheable *bool `json:"cacheable"`
+}
+
+// GetCacheStats reports the cache size and the hit ratio of logged requests
+func (h *AdminHandler) GetCacheStats(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := h.store.GetStats(ctx, h.getSince(c.DefaultQuery("duration", "24h")))
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	stats := CacheStats{
+		Backend:  h.cache.Name(),
+		Requests: result.Total,
+		Hits:     result.CacheHits,
+	}
+	if result.Total > 0 {
+		stats.HitRatio = float64(result.CacheHits) / float64(result.Total)
+	}
+	if size, err := h.cache.Size(ctx); err == nil {
+		stats.Entries = &size
+	} else {
+		log.Printf("failed to get cache size: %v", err)
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+func (h *AdminHandler) GetCacheEntry(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hash := c.Param("hash")
+	entry, err := h.cache.Get(ctx, hash)
+	if err != nil {
+		c.JSON(cacheErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	fallback, err := h.store.FindCached(time.Time{}, hash)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if entry == nil && fallback == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	info := CacheEntryInfo{Hash: hash, Log: fallback}
+	if entry != nil {
+		info.Cached = true
+		info.Model = entry.Model
+		info.Response = entry.Response
+		if !entry.StoredAt.IsZero() {
+			info.StoredAt = &entry.StoredAt
+			info.AgeSeconds = int64(entry.Age().Seconds())
+		}
+	}
+	if fallback != nil {
+		h.redactImageData(fallback)
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// DeleteCacheEntry drops a hash from the cache and excludes its logs from
+// the store fallback, so the next request goes upstream
+func (h *AdminHandler) DeleteCacheEntry(c *gin.Context) {
+	hash := c.Param("hash")
+	h.purge(c, store.CacheFilter{RequestHash: hash})
+}
+
+// PurgeCache drops every response logged for a model and/or time range
+func (h *AdminHandler) PurgeCache(c *gin.Context) {
+	var req cachePurgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := store.CacheFilter{Model: req.Model, Since: req.Since, Until: req.Until}
+	if filter.IsEmpty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model, since or until required"})
+		return
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must be before until"})
+		return
+	}
+
+	h.purge(c, filter)
+}
+
+// purge excludes matching logs first: the store returns hashes of already
+// excluded logs too, so a purge that failed on the cache can just be retried
+func (h *AdminHandler) purge(c *gin.Context, filter store.CacheFilter) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	hashes, err := h.store.ExcludeFromCache(ctx, filter)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if filter.RequestHash != "" && len(hashes) == 0 {
+		// cached without a log, e.g. while the store was down
+		hashes = []string{filter.RequestHash}
+	}
+
+	for _, hash := range hashes {
+		h.semantic.Remove(hash)
+		if err := h.cache.Delete(ctx, hash); err != nil {
+			c.JSON(cacheErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	log.Printf("purged %d cached responses", len(hashes))
+	c.JSON(http.StatusOK, gin.H{"purged": len(hashes), "hashes": hashes})
+}
+
+// SetRequestCacheable marks one log as usable by the store fallback or not.
+// excluding it also drops its hash from the cache, which the fallback refills
+// from another cacheable log of the same request, if any
+func (h *AdminHandler) SetRequestCacheable(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var req cacheableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Cacheable == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cacheable required"})
+		return
+	}
+
+	id := c.Param("id")
+	if err := h.store.SetCacheable(ctx, id, *req.Cacheable); err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if !*req.Cacheable {
+		requestLog, err := h.store.FindByID(ctx, id)
+		if err != nil {
+			c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if requestLog != nil && requestLog.RequestHash != "" {
+			if err := h.cache.Delete(ctx, requestLog.RequestHash); err != nil {
+				c.JSON(cacheErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "cacheable": *req.Cacheable})
+}
+
+// cacheErrorStatus maps a degraded cache to 503 so clients know to retry
+func cacheErrorStatus(err error) int {
+	if errors.Is(err, cache.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
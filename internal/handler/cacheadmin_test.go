@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/cache"
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/semantic"
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPurgeCacheDropsBothTiers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	logStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+	responseCache := cache.NewMemoryCache(10, time.Minute)
+
+	resp := &models.GeminiResponse{UsageMetadata: models.UsageMetadata{TotalTokenCount: 1}}
+	for _, l := range []*store.RequestLog{
+		{Timestamp: time.Now(), Model: "a", RequestHash: "h1", Success: true, Response: resp},
+		{Timestamp: time.Now(), Model: "b", RequestHash: "h2", Success: true, Response: resp},
+	} {
+		if err := logStore.LogRequest(l); err != nil {
+			t.Fatal(err)
+		}
+		responseCache.Set(ctx, l.RequestHash, cache.Entry{Response: resp, Model: l.Model}, 0)
+	}
+
+	h := NewAdminHandler(logStore, responseCache, semantic.NewIndex(10), nil, nil)
+	r := gin.New()
+	r.POST("/admin/cache/purge", h.PurgeCache)
+
+	send := func(body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/cache/purge", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := send(`{}`); code != http.StatusBadRequest {
+		t.Errorf("expected an empty purge to be rejected, got %d", code)
+	}
+	if code := send(`{"model":"a"}`); code != http.StatusOK {
+		t.Fatalf("expected purge to succeed, got %d", code)
+	}
+
+	if entry, _ := responseCache.Get(ctx, "h1"); entry != nil {
+		t.Error("expected h1 to be gone from the cache")
+	}
+	if cached, _ := logStore.FindCached(time.Time{}, "h1"); cached != nil {
+		t.Error("expected h1 to be excluded from the store fallback")
+	}
+	if entry, _ := responseCache.Get(ctx, "h2"); entry == nil {
+		t.Error("expected h2 to stay cached")
+	}
+}
+
+func TestDeleteCacheEntryReachesSemanticHits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	logStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+	responseCache := cache.NewMemoryCache(10, time.Minute)
+	index := semantic.NewIndex(10)
+
+	req := models.GeminiRequest{Contents: []models.Content{{Parts: []models.Part{{Text: "what is a cache"}}}}}
+	source, near := store.HashRequest("m", req), "v2:near"
+	resp := &models.GeminiResponse{UsageMetadata: models.UsageMetadata{TotalTokenCount: 1}}
+	hit := &store.RequestLog{Timestamp: time.Now(), Model: "m", Request: req, RequestHash: near, SourceHash: source,
+		CacheHit: true, CacheSource: semanticSource, Success: true, Response: resp}
+	for _, l := range []*store.RequestLog{
+		{Timestamp: time.Now(), Model: "m", Request: req, RequestHash: source, Success: true, Response: resp},
+		hit,
+	} {
+		if err := logStore.LogRequest(l); err != nil {
+			t.Fatal(err)
+		}
+		responseCache.Set(ctx, l.RequestHash, cache.Entry{Response: resp, Model: l.Model}, 0)
+	}
+	index.Add(semantic.KeyOf("m", req), source)
+
+	h := NewAdminHandler(logStore, responseCache, index, nil, nil)
+	r := gin.New()
+	r.DELETE("/admin/cache/entries/:hash", h.DeleteCacheEntry)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/cache/entries/"+source, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected purge to succeed, got %d", w.Code)
+	}
+
+	for _, hash := range []string{source, near} {
+		if entry, _ := responseCache.Get(ctx, hash); entry != nil {
+			t.Errorf("expected %s to be gone from the cache", hash)
+		}
+	}
+	if logged, _ := logStore.FindByID(ctx, hit.ID); logged == nil || !logged.NoCache {
+		t.Errorf("expected the semantic hit to be excluded, got %+v", logged)
+	}
+	if index.Len() != 0 {
+		t.Error("expected the purged hash to be dropped from the semantic index")
+	}
+}
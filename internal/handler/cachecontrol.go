@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxTTLSeconds is the longest X-Cache-TTL a time.Duration holds
+const maxTTLSeconds = int64(math.MaxInt64 / time.Second)
+
+// cacheControl is what the client asked of the cache through request headers
+type cacheControl struct {
+	bypass  bool          // X-Cache-Bypass: neither read nor write the cache
+	refresh bool          // X-Cache-Refresh: skip the lookup, store the new response
+	force   bool          // X-Cache-Force: cache even above max_temp
+	ttl     time.Duration // X-Cache-TTL: seconds to keep the new entry, 0 = default
+}
+
+func parseCacheControl(header http.Header) (cacheControl, error) {
+	var cc cacheControl
+	var err error
+
+	flag := func(name string) bool {
+		val := header.Get(name)
+		if val == "" || err != nil {
+			return false
+		}
+		b, parseErr := strconv.ParseBool(val)
+		if parseErr != nil {
+			err = fmt.Errorf("%s must be true or false, got '%s'", name, val)
+		}
+		return b
+	}
+	cc.bypass = flag("X-Cache-Bypass")
+	cc.refresh = flag("X-Cache-Refresh")
+	cc.force = flag("X-Cache-Force")
+	if err != nil {
+		return cc, err
+	}
+
+	if val := header.Get("X-Cache-TTL"); val != "" {
+		// capped at cache.max_ttl once the answering model is known
+		seconds, parseErr := strconv.ParseInt(val, 10, 64)
+		if parseErr != nil || seconds <= 0 || seconds > maxTTLSeconds {
+			return cc, fmt.Errorf("X-Cache-TTL must be a positive number of seconds, got '%s'", val)
+		}
+		cc.ttl = time.Duration(seconds) * time.Second
+	}
+	return cc, nil
+}
+
+// missStatus is the X-Cache-Status of a response that didn't come from the cache
+func (cc cacheControl) missStatus() string {
+	switch {
+	case cc.bypass:
+		return "BYPASS"
+	case cc.refresh:
+		return "REFRESH"
+	default:
+		return "MISS"
+	}
+}
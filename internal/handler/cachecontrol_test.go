@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Cache-Refresh", "true")
+	header.Set("X-Cache-Force", "1")
+	header.Set("X-Cache-TTL", "600")
+
+	cc, err := parseCacheControl(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.bypass || !cc.refresh || !cc.force || cc.ttl != 10*time.Minute {
+		t.Errorf("unexpected %+v", cc)
+	}
+	if status := cc.missStatus(); status != "REFRESH" {
+		t.Errorf("expected REFRESH, got %s", status)
+	}
+}
+
+func TestParseCacheControlDefaults(t *testing.T) {
+	cc, err := parseCacheControl(http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc != (cacheControl{}) || cc.missStatus() != "MISS" {
+		t.Errorf("expected zero value, got %+v", cc)
+	}
+}
+
+func TestParseCacheControlRejectsBadValues(t *testing.T) {
+	for _, h := range []struct{ name, val string }{
+		{"X-Cache-Bypass", "yes"},
+		{"X-Cache-TTL", "0"},
+		{"X-Cache-TTL", "-60"},
+		{"X-Cache-TTL", "1e3"},
+		{"X-Cache-TTL", "9223372037"},           // overflows a time.Duration
+		{"X-Cache-TTL", "99999999999999999999"}, // overflows an int64
+	} {
+		header := http.Header{}
+		header.Set(h.name, h.val)
+		if _, err := parseCacheControl(header); err == nil {
+			t.Errorf("expected %s: %s to be rejected", h.name, h.val)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"sync"
+
+	"ai-wrap/internal/cache"
+)
+
+// coalescedSource is the cache_source of requests answered by another
+// in-flight call for the same prompt
+const coalescedSource = "coalesced"
+
+// inflight coalesces concurrent identical cacheable requests. the first caller
+// for a hash leads and calls upstream, the rest wait for its response instead
+// of paying for the same prompt again
+type inflight struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done  chan struct{}
+	once  sync.Once
+	entry *cache.Entry // nil if the leader failed
+}
+
+func newInflight() *inflight {
+	return &inflight{calls: make(map[string]*flight)}
+}
+
+// join returns the flight for hash and whether the caller leads it
+func (g *inflight) join(hash string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.calls[hash]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	g.calls[hash] = f
+	return f, true
+}
+
+// finish hands entry (nil on failure) to the waiters. only the first call
+// counts, so the leader can publish on success and still defer a finish(nil)
+func (g *inflight) finish(hash string, f *flight, entry *cache.Entry) {
+	f.once.Do(func() {
+		f.entry = entry
+		close(f.done)
+
+		g.mu.Lock()
+		if g.calls[hash] == f {
+			delete(g.calls, hash)
+		}
+		g.mu.Unlock()
+	})
+}
+
+// wait blocks until the leader finishes. it returns nil if the leader failed
+// (its error may be its own, e.g. a bad user key) or ctx ended first
+func (f *flight) wait(ctx context.Context) *cache.Entry {
+	select {
+	case <-f.done:
+		return f.entry
+	case <-ctx.Done():
+		return nil
+	}
+}
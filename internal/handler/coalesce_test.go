@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/cache"
+	"ai-wrap/internal/models"
+)
+
+func TestInflightSharesLeaderResponse(t *testing.T) {
+	g := newInflight()
+
+	f, leader := g.join("h")
+	if !leader {
+		t.Fatal("expected first caller to lead")
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*cache.Entry, 5)
+	for i := range results {
+		follower, leads := g.join("h")
+		if leads {
+			t.Fatal("expected later callers to follow")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = follower.wait(context.Background())
+		}()
+	}
+
+	entry := &cache.Entry{Response: &models.GeminiResponse{ModelVersion: "v"}, Model: "m"}
+	g.finish("h", f, entry)
+	g.finish("h", f, nil) // the deferred failure must not override it
+	wg.Wait()
+
+	for i, got := range results {
+		if got != entry {
+			t.Errorf("follower %d got %v", i, got)
+		}
+	}
+	if _, leader := g.join("h"); !leader {
+		t.Error("expected a finished flight to be forgotten")
+	}
+}
+
+func TestInflightLeaderFailure(t *testing.T) {
+	g := newInflight()
+	f, _ := g.join("h")
+	follower, _ := g.join("h")
+
+	g.finish("h", f, nil)
+	if got := follower.wait(context.Background()); got != nil {
+		t.Errorf("expected nil after leader failure, got %v", got)
+	}
+}
+
+func TestInflightWaitHonorsContext(t *testing.T) {
+	g := newInflight()
+	g.join("h")
+	follower, _ := g.join("h")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if got := follower.wait(ctx); got != nil {
+		t.Errorf("expected nil on timeout, got %v", got)
+	}
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/models"
+)
+
+const (
+	defaultMaxOutputTokens = 8192
+	// estimatedImageTokens is what gemini charges for an image up to 384px
+	estimatedImageTokens = 258
+)
+
+// calculateCost prices usage per modality at the rates of the prompt's length
+// tier. cached prompt tokens are billed at the cached rate, tool use prompt
+// tokens as input and thoughts as text output
+func (h *ProxyHandler) calculateCost(usage models.UsageMetadata, modelCost config.ModelCost) models.Cost {
+	var cost models.Cost
+	add := func(kind, modality string, tokens int, rate float64) {
+		if tokens <= 0 {
+			return
+		}
+		item := models.CostItem{
+			Kind:     kind,
+			Modality: modality,
+			Tokens:   tokens,
+			Rate:     rate,
+			Cost:     float64(tokens) * rate / 1_000_000,
+		}
+		cost.Breakdown = append(cost.Breakdown, item)
+		if kind == "output" || kind == "thoughts" {
+			cost.Output += item.Cost
+		} else {
+			cost.Input += item.Cost
+		}
+	}
+
+	tier := usage.PromptTokenCount
+	cached := modalityCounts(usage.CacheTokensDetails, usage.CachedContentTokenCount)
+
+	for _, prompt := range modalityCounts(usage.PromptTokensDetails, usage.PromptTokenCount) {
+		rates := modelCost.RatesFor(tier, prompt.Modality)
+		cachedTokens := min(countFor(cached, prompt.Modality), prompt.TokenCount)
+		add("input", prompt.Modality, prompt.TokenCount-cachedTokens, rates.Input)
+		add("cached_input", prompt.Modality, cachedTokens, rates.CachedInput)
+	}
+	for _, toolUse := range modalityCounts(usage.ToolUsePromptTokensDetails, usage.ToolUsePromptTokenCount) {
+		add("tool_use_input", toolUse.Modality, toolUse.TokenCount, modelCost.RatesFor(tier, toolUse.Modality).Input)
+	}
+	for _, output := range modalityCounts(usage.CandidatesTokensDetails, usage.CandidatesTokenCount) {
+		add("output", output.Modality, output.TokenCount, modelCost.RatesFor(tier, output.Modality).Output)
+	}
+	add("thoughts", "TEXT", usage.ThoughtsTokenCount, modelCost.RatesFor(tier, "TEXT").Output)
+
+	cost.Total = cost.Input + cost.Output
+	return cost
+}
+
+// modalityCounts returns the per modality counts, with anything the details
+// don't account for attributed to text
+func modalityCounts(details []models.ModalityTokenCount, total int) []models.ModalityTokenCount {
+	counts := slices.Clone(details)
+	sum := 0
+	for _, d := range details {
+		sum += d.TokenCount
+	}
+	if rest := total - sum; rest > 0 {
+		i := slices.IndexFunc(counts, func(d models.ModalityTokenCount) bool { return strings.EqualFold(d.Modality, "TEXT") })
+		if i >= 0 {
+			counts[i].TokenCount += rest
+		} else {
+			counts = append(counts, models.ModalityTokenCount{Modality: "TEXT", TokenCount: rest})
+		}
+	}
+	return counts
+}
+
+func countFor(counts []models.ModalityTokenCount, modality string) int {
+	for _, c := range counts {
+		if strings.EqualFold(c.Modality, modality) {
+			return c.TokenCount
+		}
+	}
+	return 0
+}
+
+// predictCost prices the worst case: the estimated (or counted) prompt plus
+// maxOutputTokens of output, as image output if the request allows it
+func (h *ProxyHandler) predictCost(model string, req models.GeminiRequest, modelCost config.ModelCost, userAPIKey string) float64 {
+	usage := models.UsageMetadata{
+		PromptTokensDetails: h.estimatePromptTokens(req),
+	}
+	if h.cfg.Get().Costs.CountTokens {
+		counted, err := h.tokens.Count(model, req, userAPIKey)
+		if err != nil {
+			log.Printf("count tokens failed, falling back to estimate: %v", err)
+		} else {
+			usage.PromptTokensDetails = counted
+		}
+	}
+	for _, d := range usage.PromptTokensDetails {
+		usage.PromptTokenCount += d.TokenCount
+	}
+
+	usage.CandidatesTokenCount = defaultMaxOutputTokens
+	if req.GenerationConfig.MaxOutputTokens != nil {
+		usage.CandidatesTokenCount = *req.GenerationConfig.MaxOutputTokens
+	}
+	if slices.ContainsFunc(req.GenerationConfig.ResponseModalities, func(m string) bool { return strings.EqualFold(m, "IMAGE") }) {
+		usage.CandidatesTokensDetails = []models.ModalityTokenCount{
+			{Modality: "IMAGE", TokenCount: usage.CandidatesTokenCount},
+		}
+	}
+
+	return h.calculateCost(usage, modelCost).Total
+}
+
+// estimatePromptTokens is the offline guess: ~4 chars per text token and a
+// fixed count per media part, by modality. the system instruction, tool
+// declarations and function call payloads are billed as prompt too
+func (h *ProxyHandler) estimatePromptTokens(req models.GeminiRequest) []models.ModalityTokenCount {
+	totalChars := len(req.Tools)
+	media := map[string]int{}
+
+	contents := req.Contents
+	if req.SystemInstruction != nil {
+		contents = append([]models.Content{*req.SystemInstruction}, contents...)
+	}
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			totalChars += len(part.Text)
+			if part.FunctionCall != nil {
+				totalChars += len(part.FunctionCall.Name) + len(part.FunctionCall.Args)
+			}
+			if part.FunctionResponse != nil {
+				totalChars += len(part.FunctionResponse.Name) + len(part.FunctionResponse.Response)
+			}
+			if part.InlineData != nil {
+				media[mediaModality(part.InlineData.MimeType)] += estimatedImageTokens
+			}
+			if part.FileData != nil {
+				media[mediaModality(part.FileData.MimeType)] += estimatedImageTokens
+			}
+		}
+	}
+
+	counts := []models.ModalityTokenCount{{Modality: "TEXT", TokenCount: totalChars / 4}}
+	for _, modality := range []string{"IMAGE", "AUDIO", "VIDEO", "DOCUMENT"} {
+		if tokens := media[modality]; tokens > 0 {
+			counts = append(counts, models.ModalityTokenCount{Modality: modality, TokenCount: tokens})
+		}
+	}
+	return counts
+}
+
+// mediaModality maps a mime type to the gemini modality it is billed as
+func mediaModality(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "AUDIO"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "VIDEO"
+	case mimeType == "application/pdf":
+		return "DOCUMENT"
+	default:
+		return "IMAGE"
+	}
+}
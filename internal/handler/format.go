@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"ai-wrap/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseFormat renders pipeline results in the shape a client api expects
+type responseFormat interface {
+	// writeReject answers requests refused by the proxy itself (bad model, cost limit)
+	writeReject(c *gin.Context, statusCode int, message string, details gin.H)
+	// writeError answers failed upstream calls, resp carries the gemini error body if any
+	writeError(c *gin.Context, statusCode int, resp *models.GeminiResponse, err error)
+	writeResponse(c *gin.Context, resp *models.GeminiResponse)
+	startStream(c *gin.Context)
+	// writeStreamChunk gets the raw upstream line (nil for synthesized events) and
+	// the parsed chunk (nil for non-data lines)
+	writeStreamChunk(c *gin.Context, line []byte, chunk *models.GeminiResponse) error
+	endStream(c *gin.Context)
+}
+
+// geminiFormat passes gemini responses through unchanged
+type geminiFormat struct{}
+
+func (geminiFormat) writeReject(c *gin.Context, statusCode int, message string, details gin.H) {
+	body := gin.H{"error": message}
+	for k, v := range details {
+		body[k] = v
+	}
+	c.JSON(statusCode, body)
+}
+
+func (geminiFormat) writeError(c *gin.Context, statusCode int, resp *models.GeminiResponse, err error) {
+	if resp != nil && resp.Error != nil && resp.Error.Code != 0 {
+		c.JSON(statusCode, resp)
+	} else {
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+	}
+}
+
+func (geminiFormat) writeResponse(c *gin.Context, resp *models.GeminiResponse) {
+	if len(resp.Raw) > 0 {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", resp.Raw)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (geminiFormat) startStream(c *gin.Context) {
+	setStreamHeaders(c)
+	c.Status(http.StatusOK)
+}
+
+func (geminiFormat) writeStreamChunk(c *gin.Context, line []byte, chunk *models.GeminiResponse) error {
+	if line == nil {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		// an sse event is one line, the raw body may be pretty printed
+		var raw bytes.Buffer
+		if len(chunk.Raw) > 0 && json.Compact(&raw, chunk.Raw) == nil {
+			data = raw.Bytes()
+		}
+		line = []byte(fmt.Sprintf("data: %s\r\n\r\n", data))
+	}
+	if _, err := c.Writer.Write(line); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+func (geminiFormat) endStream(c *gin.Context) {}
+
+func setStreamHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+}
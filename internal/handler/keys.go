@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"ai-wrap/internal/keymanager"
+
+	"github.com/gin-gonic/gin"
+)
+
+type KeyInfo struct {
+	ID            string     `json:"id"`
+	Key           string     `json:"key"`
+	Provider      string     `json:"provider"`
+	Active        bool       `json:"active"`
+	InRotation    bool       `json:"in_rotation"`
+	WorkingModels []string   `json:"working_models"`
+	CheckedAt     time.Time  `json:"checked_at"`
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+}
+
+type addKeyRequest struct {
+	Key      string `json:"key" binding:"required"`
+	Provider string `json:"provider"`
+}
+
+func (h *AdminHandler) keyInfo(k keymanager.Key) KeyInfo {
+	info := KeyInfo{
+		ID:            k.ID(),
+		Key:           k.Masked(),
+		Provider:      k.Provider,
+		Active:        k.Active,
+		InRotation:    h.km.InRotation(k.Value),
+		WorkingModels: k.Models(),
+		CheckedAt:     k.CheckedAt,
+	}
+	if until := h.km.CooldownUntil(k.Value); !until.IsZero() {
+		info.CooldownUntil = &until
+	}
+	return info
+}
+
+func (h *AdminHandler) ListKeys(c *gin.Context) {
+	keys := h.km.List()
+	infos := make([]KeyInfo, 0, len(keys))
+	for _, k := range keys {
+		infos = append(infos, h.keyInfo(k))
+	}
+	c.JSON(http.StatusOK, infos)
+}
+
+func (h *AdminHandler) AddKey(c *gin.Context) {
+	var req addKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := keymanager.Key{
+		Value:    req.Key,
+		Provider: req.Provider,
+		Active:   true,
+	}
+	if err := h.km.Add(key); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	added, _ := h.km.Find(key.ID())
+	c.JSON(http.StatusCreated, h.keyInfo(added))
+}
+
+func (h *AdminHandler) DeactivateKey(c *gin.Context) {
+	h.setKeyActive(c, false)
+}
+
+func (h *AdminHandler) ReactivateKey(c *gin.Context) {
+	h.setKeyActive(c, true)
+}
+
+func (h *AdminHandler) setKeyActive(c *gin.Context, active bool) {
+	key, ok := h.km.Find(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	if err := h.km.SetActive(key.Value, active); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, _ = h.km.Find(key.ID())
+	c.JSON(http.StatusOK, h.keyInfo(key))
+}
+
+// TestKey probes the key against every configured model and stores the
+// working models and check time. it does not change whether the key is active.
+// inconclusive models (rate limits, 5xx, network errors) keep their previous
+// state, a result with nothing but those is returned but not stored
+func (h *AdminHandler) TestKey(c *gin.Context) {
+	key, ok := h.km.Find(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Minute)
+	defer cancel()
+
+	result := h.checker.Check(ctx, key.Value)
+	if result.Conclusive() {
+		if err := h.km.RecordCheck(key.Value, result); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	key, _ = h.km.Find(key.ID())
+	c.JSON(http.StatusOK, gin.H{
+		"key":    h.keyInfo(key),
+		"result": result,
+	})
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/cache"
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/semantic"
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLookupCachedKeepsModels(t *testing.T) {
+	ctx := context.Background()
+
+	logStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+
+	cfg := &config.Config{
+		Storage: config.StorageConfig{Backend: "bolt"},
+		Routing: config.RoutingConfig{Aliases: map[string]string{"fast": "flash"}},
+	}
+	req := models.GeminiRequest{Contents: []models.Content{{Parts: []models.Part{{Text: "hi"}}}}}
+	resp := &models.GeminiResponse{ModelVersion: "v"}
+
+	// a v1 log for "fast", answered by its fallback
+	legacy := &store.RequestLog{Timestamp: time.Now(), Model: "lite", RequestedModel: "fast", Request: req,
+		RequestHash: store.LegacyHashRequest(req), Success: true, Response: resp}
+	if err := logStore.LogRequest(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &ProxyHandler{cache: cache.NewMemoryCache(10, time.Minute), store: logStore}
+
+	if entry, _ := h.lookupCached(ctx, cfg, "pro", store.HashRequest("pro", req), store.LegacyHashRequest(req)); entry != nil {
+		t.Error("expected a v1 log sent to another model not to be served")
+	}
+
+	hash := store.HashRequest("flash", req)
+	entry, source := h.lookupCached(ctx, cfg, "flash", hash, store.LegacyHashRequest(req))
+	if entry == nil || source != "bolt" || entry.Model != "lite" {
+		t.Fatalf("expected the v1 log with its answering model from bolt, got %+v from %q", entry, source)
+	}
+	if cached, _ := h.cache.Get(ctx, hash); cached == nil || cached.Model != "lite" {
+		t.Errorf("expected the cache refilled with the answering model, got %+v", cached)
+	}
+}
+
+func TestSemanticHitStaysOutOfStoreFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+
+	cfg := &config.Config{Storage: config.StorageConfig{Backend: "bolt"}}
+	req := models.GeminiRequest{Contents: []models.Content{{Parts: []models.Part{{Text: "hi there"}}}}}
+	hash := store.HashRequest("flash", req)
+	entry := &cache.Entry{Response: &models.GeminiResponse{ModelVersion: "v"}, Model: "flash", StoredAt: time.Now()}
+
+	h := &ProxyHandler{cache: cache.NewMemoryCache(10, time.Minute), store: logStore}
+	call := proxyCall{model: "flash", req: req, requestHash: hash, match: semantic.Match{Hash: "v2:source", Similarity: 0.97}, startTime: time.Now()}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	h.serveCached(c, cfg, call, geminiFormat{}, entry, semanticSource)
+
+	// the hit is logged in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if n, _ := logStore.Count(context.Background()); n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("semantic hit was never logged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if found, err := logStore.FindCached(time.Time{}, hash); err != nil || found != nil {
+		t.Errorf("expected a semantic hit never to answer its own hash, got %+v (%v)", found, err)
+	}
+}
@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChatCompletions serves the openai chat completions api by translating to and
+// from gemini around the regular proxy pipeline
+func (h *ProxyHandler) ChatCompletions(c *gin.Context) {
+	var req models.ChatCompletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeOpenAIError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	geminiReq, err := toGeminiRequest(req)
+	if err != nil {
+		writeOpenAIError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	model := strings.TrimPrefix(req.Model, "models/")
+	format := &openAIFormat{
+		id:           newCompletionID(),
+		model:        model,
+		created:      time.Now().Unix(),
+		includeUsage: req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+	}
+
+	userAPIKey, virtualKey, ok := h.authenticate(c, openAIPresentedKey(c), format)
+	if !ok {
+		return
+	}
+
+	h.run(c, proxyCall{
+		model:      model,
+		req:        geminiReq,
+		userAPIKey: userAPIKey,
+		virtualKey: virtualKey,
+		stream:     req.Stream,
+	}, format)
+}
+
+// openAIPresentedKey picks the key from ?key= or the bearer token. openai sdks
+// insist on sending some key, so a bearer that is neither a gemini key nor a
+// virtual key means "use the pool"
+func openAIPresentedKey(c *gin.Context) string {
+	if key := c.Query("key"); key != "" {
+		return key
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if strings.HasPrefix(token, "AIza") || strings.HasPrefix(token, store.VirtualKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+func toGeminiRequest(req models.ChatCompletionRequest) (models.GeminiRequest, error) {
+	var out models.GeminiRequest
+	var system []models.Part
+
+	for i, msg := range req.Messages {
+		parts, err := chatContentParts(msg.Content)
+		if err != nil {
+			return out, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		switch msg.Role {
+		case "system", "developer":
+			system = append(system, parts...)
+		case "user":
+			out.Contents = append(out.Contents, models.Content{Role: "user", Parts: parts})
+		case "assistant":
+			out.Contents = append(out.Contents, models.Content{Role: "model", Parts: parts})
+		default:
+			return out, fmt.Errorf("messages[%d]: unsupported role '%s'", i, msg.Role)
+		}
+	}
+
+	if len(out.Contents) == 0 {
+		return out, fmt.Errorf("at least one user or assistant message is required")
+	}
+	if len(system) > 0 {
+		out.SystemInstruction = &models.Content{Parts: system}
+	}
+
+	out.GenerationConfig.Temperature = req.Temperature
+	out.GenerationConfig.TopP = req.TopP
+	out.GenerationConfig.CandidateCount = req.N
+	out.GenerationConfig.MaxOutputTokens = req.MaxTokens
+	if req.MaxCompletionTokens != nil {
+		out.GenerationConfig.MaxOutputTokens = req.MaxCompletionTokens
+	}
+
+	return out, nil
+}
+
+// chatContentParts accepts both the plain string and the typed parts form
+func chatContentParts(raw json.RawMessage) ([]models.Part, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []models.Part{{Text: text}}, nil
+	}
+
+	var chatParts []models.ChatContentPart
+	if err := json.Unmarshal(raw, &chatParts); err != nil {
+		return nil, fmt.Errorf("content must be a string or an array of parts")
+	}
+
+	parts := make([]models.Part, 0, len(chatParts))
+	for _, p := range chatParts {
+		switch p.Type {
+		case "text":
+			parts = append(parts, models.Part{Text: p.Text})
+		case "image_url":
+			if p.ImageURL == nil {
+				return nil, fmt.Errorf("image_url part without url")
+			}
+			inline, err := parseDataURL(p.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, models.Part{InlineData: inline})
+		default:
+			return nil, fmt.Errorf("unsupported content part type '%s'", p.Type)
+		}
+	}
+
+	return parts, nil
+}
+
+// parseDataURL only accepts base64 data urls, the proxy never fetches remote images
+func parseDataURL(url string) (*models.InlineData, error) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return nil, fmt.Errorf("only base64 data: urls are supported for image_url")
+	}
+	meta, data, ok := strings.Cut(rest, ",")
+	mimeType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !ok || !isBase64 || mimeType == "" {
+		return nil, fmt.Errorf("invalid data url, expected data:<mime>;base64,<data>")
+	}
+	return &models.InlineData{MimeType: mimeType, Data: data}, nil
+}
+
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+// candidateText is the answer text, thought summaries are left out
+func candidateText(cand models.Candidate) string {
+	var sb strings.Builder
+	for _, part := range cand.Content.Parts {
+		if !part.Thought {
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+func openAIFinishReason(reason string) *string {
+	var mapped string
+	switch reason {
+	case "":
+		return nil
+	case "MAX_TOKENS":
+		mapped = "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		mapped = "content_filter"
+	default:
+		mapped = "stop"
+	}
+	return &mapped
+}
+
+// openAIUsage counts thoughts as completion tokens, like openai reasoning tokens
+func openAIUsage(usage models.UsageMetadata) *models.ChatUsage {
+	out := &models.ChatUsage{
+		PromptTokens:     usage.PromptTokenCount + usage.ToolUsePromptTokenCount,
+		CompletionTokens: usage.CandidatesTokenCount + usage.ThoughtsTokenCount,
+		TotalTokens:      usage.TotalTokenCount,
+	}
+	if usage.CachedContentTokenCount > 0 {
+		out.PromptTokensDetails = &models.ChatPromptTokensDetails{CachedTokens: usage.CachedContentTokenCount}
+	}
+	if usage.ThoughtsTokenCount > 0 {
+		out.CompletionTokensDetails = &models.ChatCompletionDetails{ReasoningTokens: usage.ThoughtsTokenCount}
+	}
+	return out
+}
+
+func openAIErrorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusNotFound:
+		return "invalid_request_error"
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "authentication_error"
+	case http.StatusPaymentRequired:
+		return "insufficient_quota"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	default:
+		return "api_error"
+	}
+}
+
+func writeOpenAIError(c *gin.Context, statusCode int, message string) {
+	c.JSON(statusCode, models.ChatErrorResponse{
+		Error: models.ChatError{
+			Message: message,
+			Type:    openAIErrorType(statusCode),
+			Code:    statusCode,
+		},
+	})
+}
+
+// openAIFormat renders gemini responses as chat completions. it is per request
+// since streaming needs to remember what was already sent
+type openAIFormat struct {
+	id           string
+	model        string
+	created      int64
+	includeUsage bool
+	sentRole     map[int]bool
+	usage        *models.ChatUsage
+}
+
+func (f *openAIFormat) writeReject(c *gin.Context, statusCode int, message string, details gin.H) {
+	writeOpenAIError(c, statusCode, message)
+}
+
+func (f *openAIFormat) writeError(c *gin.Context, statusCode int, resp *models.GeminiResponse, err error) {
+	message := err.Error()
+	if resp != nil && resp.Error != nil && resp.Error.Message != "" {
+		message = resp.Error.Message
+	}
+	writeOpenAIError(c, statusCode, message)
+}
+
+func (f *openAIFormat) writeResponse(c *gin.Context, resp *models.GeminiResponse) {
+	out := models.ChatCompletionResponse{
+		ID:      f.id,
+		Object:  "chat.completion",
+		Created: f.created,
+		Model:   f.model,
+		Choices: make([]models.ChatChoice, 0, len(resp.Candidates)),
+		Usage:   openAIUsage(resp.UsageMetadata),
+	}
+
+	for i, cand := range resp.Candidates {
+		out.Choices = append(out.Choices, models.ChatChoice{
+			Index:        i,
+			Message:      &models.ChatResponseMessage{Role: "assistant", Content: candidateText(cand)},
+			FinishReason: openAIFinishReason(cand.FinishReason),
+		})
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+func (f *openAIFormat) startStream(c *gin.Context) {
+	f.sentRole = make(map[int]bool)
+	setStreamHeaders(c)
+	c.Status(http.StatusOK)
+}
+
+func (f *openAIFormat) writeStreamChunk(c *gin.Context, line []byte, chunk *models.GeminiResponse) error {
+	if chunk == nil {
+		return nil
+	}
+
+	out := models.ChatCompletionResponse{
+		ID:      f.id,
+		Object:  "chat.completion.chunk",
+		Created: f.created,
+		Model:   f.model,
+		Choices: make([]models.ChatChoice, 0, len(chunk.Candidates)),
+	}
+
+	for i, cand := range chunk.Candidates {
+		delta := &models.ChatResponseMessage{Content: candidateText(cand)}
+		if !f.sentRole[i] {
+			delta.Role = "assistant"
+			f.sentRole[i] = true
+		}
+		out.Choices = append(out.Choices, models.ChatChoice{
+			Index:        i,
+			Delta:        delta,
+			FinishReason: openAIFinishReason(cand.FinishReason),
+		})
+	}
+
+	if chunk.UsageMetadata.TotalTokenCount > 0 {
+		f.usage = openAIUsage(chunk.UsageMetadata)
+	}
+
+	return f.writeEvent(c, out)
+}
+
+func (f *openAIFormat) endStream(c *gin.Context) {
+	if f.includeUsage && f.usage != nil {
+		f.writeEvent(c, models.ChatCompletionResponse{
+			ID:      f.id,
+			Object:  "chat.completion.chunk",
+			Created: f.created,
+			Model:   f.model,
+			Choices: []models.ChatChoice{},
+			Usage:   f.usage,
+		})
+	}
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+func (f *openAIFormat) writeEvent(c *gin.Context, event models.ChatCompletionResponse) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
@@ -1,51 +1,135 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"ai-wrap/internal/breaker"
 	"ai-wrap/internal/cache"
 	"ai-wrap/internal/client"
 	"ai-wrap/internal/config"
 	"ai-wrap/internal/keymanager"
 	"ai-wrap/internal/models"
+	"ai-wrap/internal/semantic"
 	"ai-wrap/internal/store"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ProxyHandler struct {
-	cfg    *config.Config
-	cache  *cache.RedisCache
-	store  *store.MongoStore
-	client *client.GeminiClient
-	km     *keymanager.KeyManager
+	cfg      *config.Holder
+	cache    cache.Cache
+	store    store.Store
+	client   *client.GeminiClient
+	km       *keymanager.KeyManager
+	tokens   *tokenCounter
+	inflight *inflight
+	semantic *semantic.Index
+	budgets  budgets
 }
 
-func NewProxyHandler(cfg *config.Config, redisCache *cache.RedisCache, mongoStore *store.MongoStore, geminiClient *client.GeminiClient, km *keymanager.KeyManager) *ProxyHandler {
+// proxyCall is a single generate request moving through the pipeline
+type proxyCall struct {
+	// requestedModel is the name the client sent, model the configured model
+	// currently serving it (after aliases and fallbacks)
+	requestedModel string
+	model          string
+	chain          []string
+	modelCost      config.ModelCost
+	req            models.GeminiRequest
+	userAPIKey     string
+	virtualKey     *store.VirtualKey
+	stream         bool
+	temp           float64
+	requestHash    string
+	cacheEnabled   bool
+	cacheControl   cacheControl
+	startTime      time.Time
+	// flight is set when this call leads a coalesced group
+	flight *flight
+	// semanticKey is set when the semantic cache is on for this call
+	semanticKey *semantic.Key
+	// match is the near duplicate a semantic hit was served from
+	match semantic.Match
+	// reservation is the budget held for this call until its log is stored
+	reservation *reservation
+}
+
+func (call proxyCall) virtualKeyID() string {
+	if call.virtualKey == nil {
+		return ""
+	}
+	return call.virtualKey.ID
+}
+
+// servedBy switches the call to the fallback model that actually answered, so
+// it is priced and logged as that model
+func (call *proxyCall) servedBy(cfg *config.Config, model string) {
+	if model == "" || model == call.model {
+		return
+	}
+	call.model = model
+	call.modelCost, _ = cfg.GetModelCost(model)
+}
+
+func NewProxyHandler(cfg *config.Holder, responseCache cache.Cache, logStore store.Store, geminiClient *client.GeminiClient, km *keymanager.KeyManager, index *semantic.Index) *ProxyHandler {
 	return &ProxyHandler{
-		cfg:    cfg,
-		cache:  redisCache,
-		store:  mongoStore,
-		client: geminiClient,
-		km:     km,
+		cfg:      cfg,
+		cache:    responseCache,
+		store:    logStore,
+		client:   geminiClient,
+		km:       km,
+		tokens:   newTokenCounter(geminiClient),
+		inflight: newInflight(),
+		semantic: index,
 	}
 }
 
-func (h *ProxyHandler) logAsync(log *store.RequestLog) {
+// logAsync stores log in the background. a call's budget reservation is
+// released once its spend is logged
+func (h *ProxyHandler) logAsync(log *store.RequestLog, r *reservation) {
 	go func() {
-		if err := h.store.LogRequest(log); err != nil {
+		// a degraded store shows up in /health, no need to log every request
+		if err := h.store.LogRequest(log); err != nil && !errors.Is(err, store.ErrUnavailable) {
 			fmt.Printf("failed to log request: %v\n", err)
 		}
+		r.release()
 	}()
 }
 
+// cacheResponse stores a successful response and then hands it to requests
+// coalesced onto this call, so a duplicate arriving in between finds the cache.
+// X-Cache-TTL wins over the ttl configured for the model that answered, up to
+// cache.max_ttl
+func (h *ProxyHandler) cacheResponse(ctx context.Context, cfg *config.Config, call proxyCall, resp *models.GeminiResponse) {
+	ttl := cfg.Cache.TTL(call.model)
+	if call.cacheControl.ttl > 0 {
+		ttl = cfg.RequestedTTL(call.model, call.cacheControl.ttl)
+	}
+	entry := cache.Entry{Response: resp, Raw: resp.Raw, Model: call.model, StoredAt: time.Now()}
+	if err := h.cache.Set(ctx, call.requestHash, entry, ttl); err != nil && !errors.Is(err, cache.ErrUnavailable) {
+		log.Printf("failed to cache response: %v", err)
+	}
+	if call.semanticKey != nil {
+		h.semantic.Add(*call.semanticKey, call.requestHash)
+	}
+	if call.flight != nil {
+		h.inflight.finish(call.requestHash, call.flight, &entry)
+	}
+}
+
 func (h *ProxyHandler) Handle(c *gin.Context) {
 	path := c.Param("path")
-	userAPIKey := c.Query("key")
+	presentedKey := c.Query("key")
+	if presentedKey == "" {
+		presentedKey = c.GetHeader("x-goog-api-key")
+	}
 
 	parts := strings.Split(strings.TrimPrefix(path, "/"), ":")
 	if len(parts) != 2 {
@@ -56,16 +140,25 @@ func (h *ProxyHandler) Handle(c *gin.Context) {
 	model := parts[0]
 	action := parts[1]
 
-	if action != "generateContent" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "only generateContent action is supported"})
+	if action != "generateContent" && action != "streamGenerateContent" && action != "countTokens" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only generateContent, streamGenerateContent and countTokens actions are supported"})
 		return
 	}
 
-	modelCost, exists := h.cfg.GetModelCost(model)
-	if !exists {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("model '%s' not allowed. only models defined in config are permitted", model),
-		})
+	userAPIKey, virtualKey, ok := h.authenticate(c, presentedKey, geminiFormat{})
+	if !ok {
+		return
+	}
+
+	if action == "countTokens" {
+		resolved, exists := h.cfg.Get().ResolveModel(model)
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("model '%s' not allowed. only models defined in config are permitted", model),
+			})
+			return
+		}
+		h.handleCountTokens(c, resolved, userAPIKey)
 		return
 	}
 
@@ -75,84 +168,259 @@ func (h *ProxyHandler) Handle(c *gin.Context) {
 		return
 	}
 
-	predictedCost := h.predictCost(req, modelCost)
-	if h.cfg.Costs.MaxCost > 0 && predictedCost > h.cfg.Costs.MaxCost {
-		c.JSON(http.StatusPaymentRequired, gin.H{
-			"error":          fmt.Sprintf("predicted cost $%.6f exceeds maximum allowed cost $%.6f", predictedCost, h.cfg.Costs.MaxCost),
+	h.run(c, proxyCall{
+		model:      model,
+		req:        req,
+		userAPIKey: userAPIKey,
+		virtualKey: virtualKey,
+		stream:     action == "streamGenerateContent",
+	}, geminiFormat{})
+}
+
+// authenticate resolves the presented key into either a user gemini key or a
+// proxy-issued virtual key. it writes the rejection itself and returns false
+// when the request can't proceed
+func (h *ProxyHandler) authenticate(c *gin.Context, presentedKey string, format responseFormat) (string, *store.VirtualKey, bool) {
+	if strings.HasPrefix(presentedKey, store.VirtualKeyPrefix) {
+		vk, err := h.store.FindVirtualKeyByToken(c.Request.Context(), presentedKey)
+		if err != nil {
+			log.Printf("failed to look up virtual key: %v", err)
+			format.writeReject(c, http.StatusServiceUnavailable, "unable to verify virtual key", nil)
+			return "", nil, false
+		}
+		if vk == nil || !vk.Active {
+			format.writeReject(c, http.StatusUnauthorized, "invalid or inactive virtual key", nil)
+			return "", nil, false
+		}
+		return "", vk, true
+	}
+
+	if presentedKey == "" && h.cfg.Get().Auth.RequireVirtualKey {
+		format.writeReject(c, http.StatusUnauthorized, "a virtual key is required to use the key pool", nil)
+		return "", nil, false
+	}
+
+	return presentedKey, nil, true
+}
+
+// checkBudget refuses calls that would push a virtual key past its daily or
+// monthly budget, counting the calls of the key still in flight. a zero
+// budget means unlimited. a passed check reserves predictedCost until the
+// returned reservation is released
+func (h *ProxyHandler) checkBudget(c *gin.Context, vk *store.VirtualKey, predictedCost float64, format responseFormat) (*reservation, bool) {
+	if vk.DailyBudget <= 0 && vk.MonthlyBudget <= 0 {
+		return nil, true
+	}
+
+	kb := h.budgets.lock(vk.ID)
+	spend, err := h.store.GetVirtualKeySpend(c.Request.Context(), vk.ID, time.Now())
+	if err != nil {
+		h.budgets.unlock(vk.ID, kb, 0)
+		log.Printf("failed to get spend for virtual key %s: %v", vk.ID, err)
+		format.writeReject(c, http.StatusServiceUnavailable, "unable to verify virtual key budget", nil)
+		return nil, false
+	}
+	reserved := h.budgets.reserved(kb)
+
+	check := func(period string, spent, budget float64) bool {
+		if budget <= 0 || spent+reserved+predictedCost <= budget {
+			return true
+		}
+		format.writeReject(c, http.StatusPaymentRequired, fmt.Sprintf("virtual key %s budget $%.6f exceeded (spent $%.6f, in flight $%.6f, predicted $%.6f)", period, budget, spent, reserved, predictedCost), gin.H{
+			"virtual_key_id": vk.ID,
+			"budget":         budget,
+			"spent":          spent,
+			"reserved":       reserved,
 			"predicted_cost": predictedCost,
-			"max_cost":       h.cfg.Costs.MaxCost,
 		})
+		return false
+	}
+
+	if !check("daily", spend.Daily, vk.DailyBudget) || !check("monthly", spend.Monthly, vk.MonthlyBudget) {
+		h.budgets.unlock(vk.ID, kb, 0)
+		return nil, false
+	}
+	return h.budgets.unlock(vk.ID, kb, predictedCost), true
+}
+
+// lookupCached finds an exact match in the cache, then the newest log within
+// cache.fallback_max_age in the store, which may still hold older hash
+// versions listed after the current one. older versions don't cover the
+// model, so their logs only count when they were sent to model. it returns
+// the entry and the layer that had it
+func (h *ProxyHandler) lookupCached(ctx context.Context, cfg *config.Config, model, requestHash string, olderHashes ...string) (*cache.Entry, string) {
+	if cached, _ := h.cache.Get(ctx, requestHash); cached != nil {
+		return cached, h.cache.Name()
+	}
+
+	dbLog, _ := h.store.FindCached(cfg.Cache.FallbackSince(time.Now()), append([]string{requestHash}, olderHashes...)...)
+	if dbLog == nil || dbLog.Response == nil {
+		return nil, ""
+	}
+	if dbLog.RequestHash != requestHash && dbLog.HashedModel(cfg.ResolvedName) != model {
+		return nil, ""
+	}
+	source := cfg.Storage.Backend
+	entry := cache.Entry{Response: dbLog.CachedResponse(), Model: dbLog.Model, StoredAt: dbLog.Timestamp}
+	if err := h.cache.Set(ctx, requestHash, entry, cfg.Cache.TTL(dbLog.Model)); err != nil && !errors.Is(err, cache.ErrUnavailable) {
+		log.Printf("failed to populate %s cache from %s: %v", h.cache.Name(), source, err)
+	}
+	return &entry, source
+}
+
+// serveCached answers from a cached or coalesced response and logs it as a hit.
+// the hit is priced and reported as the model that answered it
+func (h *ProxyHandler) serveCached(c *gin.Context, cfg *config.Config, call proxyCall, format responseFormat, entry *cache.Entry, source string) {
+	call.servedBy(cfg, entry.Model)
+	cached := entry.Response
+	cachedCost := h.calculateCost(cached.UsageMetadata, call.modelCost)
+	log.Printf("%s cache hit for model %s (saved $%.6f)", source, call.model, cachedCost.Total)
+	h.addCostHeaders(c, cachedCost, "HIT", call.userAPIKey)
+	c.Header("X-Cache-Source", source)
+	c.Header("X-Cache-Age", strconv.Itoa(int(entry.Age().Seconds())))
+	if call.match.Similarity > 0 {
+		c.Header("X-Cache-Similarity", strconv.FormatFloat(call.match.Similarity, 'f', 4, 64))
+	}
+	c.Header("X-Model-Used", call.model)
+	if call.stream {
+		format.startStream(c)
+		format.writeStreamChunk(c, nil, cached)
+		format.endStream(c)
+	} else {
+		format.writeResponse(c, cached)
+	}
+
+	h.logAsync(&store.RequestLog{
+		Timestamp:      time.Now(),
+		Model:          call.model,
+		RequestedModel: call.requestedModel,
+		Request:        call.req,
+		Response:       cached,
+		RawResponse:    cached.Raw,
+		StatusCode:     http.StatusOK,
+		Success:        true,
+		Cost:           cachedCost,
+		Saved:          cachedCost.Total,
+		Temperature:    call.temp,
+		KeySource:      h.getKeySource(call.userAPIKey),
+		CacheHit:       true,
+		CacheSource:    source,
+		Similarity:     call.match.Similarity,
+		RequestHash:    call.requestHash,
+		SourceHash:     call.match.Hash,
+		// a near duplicate's answer must not become the answer to this request
+		NoCache:      source == semanticSource,
+		DurationMs:   time.Since(call.startTime).Milliseconds(),
+		PromptTokens: cached.UsageMetadata.PromptTokenCount,
+		OutputTokens: cached.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:  cached.UsageMetadata.TotalTokenCount,
+		IsVision:     h.isVisionRequest(call.req),
+		VirtualKeyID: call.virtualKeyID(),
+	}, nil)
+}
+
+// run takes a parsed request through cache lookup, cost blocking, upstream call
+// and logging. format decides how results are rendered for the client
+func (h *ProxyHandler) run(c *gin.Context, call proxyCall, format responseFormat) {
+	// one snapshot per request, a reload mid-request doesn't change its rules
+	cfg := h.cfg.Get()
+	cc, err := parseCacheControl(c.Request.Header)
+	if err != nil {
+		format.writeReject(c, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
+	call.cacheControl = cc
 
-	temp := h.getTemperature(req)
-	requestHash := store.HashRequest(req)
-	startTime := time.Now()
+	model, exists := cfg.ResolveModel(call.model)
+	if !exists {
+		format.writeReject(c, http.StatusBadRequest, fmt.Sprintf("model '%s' not allowed. only models defined in config are permitted", call.model), nil)
+		return
+	}
+	modelCost, _ := cfg.GetModelCost(model)
+	call.requestedModel, call.model = call.model, model
+	call.chain = cfg.ModelChain(model)
+	call.modelCost = modelCost
+
+	call.temp = h.getTemperature(call.req)
+	call.requestHash = store.HashRequest(call.model, call.req)
+	call.startTime = time.Now()
+	call.cacheEnabled = !call.cacheControl.bypass && (call.temp <= cfg.Cache.MaxTemp || call.cacheControl.force)
+	c.Header("X-Request-Hash", call.requestHash)
 	ctx := c.Request.Context()
 
-	cacheEnabled := temp <= h.cfg.Cache.MaxTemp
-	var cached *models.GeminiResponse
-	var cachedCost models.Cost
-	var cacheSource string
+	// a refresh skips the lookup and any in-flight duplicate, but still caches
+	if call.cacheEnabled && cfg.Cache.Semantic.Enabled {
+		key := semantic.KeyOf(call.model, call.req)
+		call.semanticKey = &key
+	}
 
-	if cacheEnabled {
-		cached, _ = h.cache.Get(ctx, requestHash)
-		if cached != nil {
-			cacheSource = "redis"
-		} else {
-			dbLog, _ := h.store.FindCached(requestHash)
-			if dbLog != nil && dbLog.Response != nil {
-				cached = dbLog.Response
-				cacheSource = "mongodb"
-				if err := h.cache.Set(ctx, requestHash, cached); err != nil {
-					log.Printf("failed to populate redis from mongodb: %v", err)
-				}
+	if call.cacheEnabled && !call.cacheControl.refresh {
+		if cached, cacheSource := h.lookupCached(ctx, cfg, call.model, call.requestHash, store.LegacyHashRequest(call.req)); cached != nil {
+			if call.semanticKey != nil {
+				h.semantic.Add(*call.semanticKey, call.requestHash)
 			}
+			h.serveCached(c, cfg, call, format, cached, cacheSource)
+			return
 		}
 
-		if cached != nil {
-			cachedCost = h.calculateCost(cached.UsageMetadata, modelCost)
-			log.Printf("%s cache hit for model %s (saved $%.6f)", cacheSource, model, cachedCost.Total)
-			h.addCostHeaders(c, cachedCost, true, userAPIKey)
-			c.JSON(http.StatusOK, cached)
-
-			h.logAsync(&store.RequestLog{
-				Timestamp:    time.Now(),
-				Model:        model,
-				Request:      req,
-				Response:     cached,
-				StatusCode:   http.StatusOK,
-				Success:      true,
-				Cost:         cachedCost,
-				Temperature:  temp,
-				KeySource:    h.getKeySource(userAPIKey),
-				CacheHit:     true,
-				RequestHash:  requestHash,
-				DurationMs:   0,
-				PromptTokens: cached.UsageMetadata.PromptTokenCount,
-				OutputTokens: cached.UsageMetadata.CandidatesTokenCount,
-				TotalTokens:  cached.UsageMetadata.TotalTokenCount,
-				IsVision:     h.isVisionRequest(req),
-			})
+		if call.semanticKey != nil {
+			if cached, match := h.lookupSemantic(ctx, cfg, call.model, *call.semanticKey); cached != nil {
+				call.match = match
+				h.serveCached(c, cfg, call, format, cached, semanticSource)
+				return
+			}
+		}
 
+		// identical prompts already on their way upstream are waited for
+		f, leader := h.inflight.join(call.requestHash)
+		if leader {
+			call.flight = f
+			defer h.inflight.finish(call.requestHash, f, nil)
+		} else if entry := f.wait(ctx); entry != nil {
+			h.serveCached(c, cfg, call, format, entry, coalescedSource)
+			return
+		} else if ctx.Err() != nil {
 			return
 		}
 	}
 
-	resp, statusCode, err := h.client.GenerateContent(model, req, userAPIKey)
-	duration := time.Since(startTime)
+	// only misses are priced up front, a hit costs nothing and needs no countTokens
+	predictedCost := h.predictCost(call.model, call.req, call.modelCost, call.userAPIKey)
+	if cfg.Costs.MaxCost > 0 && predictedCost > cfg.Costs.MaxCost {
+		format.writeReject(c, http.StatusPaymentRequired, fmt.Sprintf("predicted cost $%.6f exceeds maximum allowed cost $%.6f", predictedCost, cfg.Costs.MaxCost), gin.H{
+			"predicted_cost": predictedCost,
+			"max_cost":       cfg.Costs.MaxCost,
+		})
+		return
+	}
+
+	if call.virtualKey != nil {
+		r, ok := h.checkBudget(c, call.virtualKey, predictedCost, format)
+		if !ok {
+			return
+		}
+		call.reservation = r
+	}
+
+	if call.stream {
+		h.handleStream(c, cfg, call, format)
+		return
+	}
+
+	resp, servedBy, statusCode, err := h.client.GenerateContent(call.chain, call.req, call.userAPIKey)
+	duration := time.Since(call.startTime)
+	call.servedBy(cfg, servedBy)
+	c.Header("X-Model-Used", call.model)
 
 	success := err == nil && statusCode == http.StatusOK
 	var cost models.Cost
 	var errorMsg string
 
 	if success {
-		cost = h.calculateCost(resp.UsageMetadata, modelCost)
+		cost = h.calculateCost(resp.UsageMetadata, call.modelCost)
 
-		if cacheEnabled {
-			if err := h.cache.Set(ctx, requestHash, &resp); err != nil {
-				log.Printf("failed to cache response: %v", err)
-			}
+		if call.cacheEnabled {
+			h.cacheResponse(ctx, cfg, call, &resp)
 		}
 	} else {
 		errorMsg = err.Error()
@@ -160,52 +428,41 @@ func (h *ProxyHandler) Handle(c *gin.Context) {
 	}
 
 	requestLog := &store.RequestLog{
-		Timestamp:   time.Now(),
-		Model:       model,
-		Request:     req,
-		StatusCode:  statusCode,
-		Success:     success,
-		Error:       errorMsg,
-		Cost:        cost,
-		Temperature: temp,
-		KeySource:   h.getKeySource(userAPIKey),
-		CacheHit:    false,
-		RequestHash: requestHash,
-		DurationMs:  duration.Milliseconds(),
-		IsVision:    h.isVisionRequest(req),
+		Timestamp:      time.Now(),
+		Model:          call.model,
+		RequestedModel: call.requestedModel,
+		Request:        call.req,
+		StatusCode:     statusCode,
+		Success:        success,
+		Error:          errorMsg,
+		Cost:           cost,
+		Spent:          cost.Total,
+		Temperature:    call.temp,
+		KeySource:      h.getKeySource(call.userAPIKey),
+		CacheHit:       false,
+		RequestHash:    call.requestHash,
+		DurationMs:     duration.Milliseconds(),
+		IsVision:       h.isVisionRequest(call.req),
+		VirtualKeyID:   call.virtualKeyID(),
 	}
 
 	if success {
 		requestLog.Response = &resp
+		requestLog.RawResponse = resp.Raw
 		requestLog.PromptTokens = resp.UsageMetadata.PromptTokenCount
 		requestLog.OutputTokens = resp.UsageMetadata.CandidatesTokenCount
 		requestLog.TotalTokens = resp.UsageMetadata.TotalTokenCount
 	}
 
-	h.logAsync(requestLog)
+	h.logAsync(requestLog, call.reservation)
 
 	if err != nil {
-		if resp.Error != nil && resp.Error.Code != 0 {
-			c.JSON(statusCode, resp)
-		} else {
-			c.JSON(statusCode, gin.H{"error": err.Error()})
-		}
+		format.writeError(c, statusCode, &resp, err)
 		return
 	}
 
-	h.addCostHeaders(c, cost, false, userAPIKey)
-	c.JSON(http.StatusOK, resp)
-}
-
-func (h *ProxyHandler) calculateCost(usage models.UsageMetadata, modelCost config.ModelCost) models.Cost {
-	inputCost := float64(usage.PromptTokenCount) * modelCost.Input / 1_000_000
-	outputCost := float64(usage.CandidatesTokenCount) * modelCost.Output / 1_000_000
-
-	return models.Cost{
-		Input:  inputCost,
-		Output: outputCost,
-		Total:  inputCost + outputCost,
-	}
+	h.addCostHeaders(c, cost, call.cacheControl.missStatus(), call.userAPIKey)
+	format.writeResponse(c, &resp)
 }
 
 func (h *ProxyHandler) getTemperature(req models.GeminiRequest) float64 {
@@ -222,59 +479,71 @@ func (h *ProxyHandler) getKeySource(userAPIKey string) string {
 	return "pool"
 }
 
-func (h *ProxyHandler) predictCost(req models.GeminiRequest, modelCost config.ModelCost) float64 {
-	var totalChars int
-	hasImage := false
-
-	for _, content := range req.Contents {
-		for _, part := range content.Parts {
-			totalChars += len(part.Text)
-			if part.InlineData != nil {
-				hasImage = true
-			}
-		}
-	}
-
-	estimatedPromptTokens := totalChars / 4
-
-	if hasImage {
-		estimatedPromptTokens += 258
+// handleCountTokens proxies countTokens as-is. it is free upstream, so there is
+// no cost blocking, caching or logging
+func (h *ProxyHandler) handleCountTokens(c *gin.Context, model, userAPIKey string) {
+	var req models.CountTokensRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	maxOutputTokens := 8192
-	if req.GenerationConfig.MaxOutputTokens != nil {
-		maxOutputTokens = *req.GenerationConfig.MaxOutputTokens
+	resp, statusCode, err := h.client.CountTokens(model, req, userAPIKey)
+	if err != nil {
+		log.Printf("gemini api error: %v", err)
+		if resp.Error != nil && resp.Error.Code != 0 {
+			c.JSON(statusCode, resp)
+		} else {
+			c.JSON(statusCode, gin.H{"error": err.Error()})
+		}
+		return
 	}
 
-	inputCost := float64(estimatedPromptTokens) * modelCost.Input / 1_000_000
-	outputCost := float64(maxOutputTokens) * modelCost.Output / 1_000_000
-
-	return inputCost + outputCost
+	c.Header("X-Key-Source", h.getKeySource(userAPIKey))
+	c.JSON(http.StatusOK, resp)
 }
 
-func (h *ProxyHandler) addCostHeaders(c *gin.Context, cost models.Cost, cached bool, userAPIKey string) {
+func (h *ProxyHandler) addCostHeaders(c *gin.Context, cost models.Cost, cacheStatus string, userAPIKey string) {
 	c.Header("X-Cost-Input", fmt.Sprintf("%.6f", cost.Input))
 	c.Header("X-Cost-Output", fmt.Sprintf("%.6f", cost.Output))
 	c.Header("X-Cost-Total", fmt.Sprintf("%.6f", cost.Total))
-
-	cacheStatus := "MISS"
-	if cached {
-		cacheStatus = "HIT"
-	}
 	c.Header("X-Cache-Status", cacheStatus)
 	c.Header("X-Key-Source", h.getKeySource(userAPIKey))
 }
 
+// Health stays 200 while the proxy can serve upstream traffic. a missing cache
+// or store only degrades it, which is reported per dependency
 func (h *ProxyHandler) Health(c *gin.Context) {
+	deps := gin.H{
+		"cache": dependencyHealth(h.cache, h.cache.Name()),
+		"store": dependencyHealth(h.store, h.cfg.Get().Storage.Backend),
+	}
+
+	status := breaker.StatusOK
+	for _, dep := range deps {
+		if dep.(breaker.Health).Status != breaker.StatusOK {
+			status = breaker.StatusDegraded
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"status": "ok",
-		"models": h.getModelList(),
+		"status":       status,
+		"dependencies": deps,
+		"models":       h.getModelList(),
+		"aliases":      h.cfg.Get().Routing.Aliases,
 	})
 }
 
+func dependencyHealth(dep any, backend string) breaker.Health {
+	if r, ok := dep.(breaker.Reporter); ok {
+		return r.Health()
+	}
+	return breaker.Health{Backend: backend, Status: breaker.StatusOK}
+}
+
 func (h *ProxyHandler) getModelList() []string {
-	models := make([]string, 0, len(h.cfg.Costs.Models))
-	for _, model := range h.cfg.Costs.Models {
+	models := make([]string, 0, len(h.cfg.Get().Costs.Models))
+	for _, model := range h.cfg.Get().Costs.Models {
 		models = append(models, model.Name)
 	}
 	return models
@@ -283,7 +552,7 @@ func (h *ProxyHandler) getModelList() []string {
 func (h *ProxyHandler) isVisionRequest(req models.GeminiRequest) bool {
 	for _, content := range req.Contents {
 		for _, part := range content.Parts {
-			if part.InlineData != nil {
+			if part.InlineData != nil || part.FileData != nil {
 				return true
 			}
 		}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"context"
+	"log"
+
+	"ai-wrap/internal/cache"
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/semantic"
+)
+
+// semanticSource is the cache_source of near-duplicate hits
+const semanticSource = "semantic"
+
+// lookupSemantic serves a near duplicate of an already cached request. the
+// index only points at request hashes, the response itself comes from the
+// regular cache, so an index entry whose response expired is dropped
+func (h *ProxyHandler) lookupSemantic(ctx context.Context, cfg *config.Config, model string, key semantic.Key) (*cache.Entry, semantic.Match) {
+	match, ok := h.semantic.Lookup(key, cfg.Cache.Semantic.Threshold)
+	if !ok {
+		return nil, semantic.Match{}
+	}
+
+	cached, _ := h.lookupCached(ctx, cfg, model, match.Hash)
+	if cached == nil {
+		h.semantic.Remove(match.Hash)
+		return nil, semantic.Match{}
+	}
+	log.Printf("semantic match %.4f with %s", match.Similarity, match.Hash[:12])
+	return cached, match
+}
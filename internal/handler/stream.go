@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleStream forwards upstream sse events to the client as they arrive and
+// assembles them into a single response for costing, caching and logging
+func (h *ProxyHandler) handleStream(c *gin.Context, cfg *config.Config, call proxyCall, format responseFormat) {
+	body, errResp, servedBy, statusCode, err := h.client.StreamGenerateContent(call.chain, call.req, call.userAPIKey)
+	call.servedBy(cfg, servedBy)
+	c.Header("X-Model-Used", call.model)
+	if err != nil {
+		log.Printf("gemini api error: %v", err)
+
+		h.logAsync(&store.RequestLog{
+			Timestamp:      time.Now(),
+			Model:          call.model,
+			RequestedModel: call.requestedModel,
+			Request:        call.req,
+			StatusCode:     statusCode,
+			Success:        false,
+			Error:          err.Error(),
+			Temperature:    call.temp,
+			KeySource:      h.getKeySource(call.userAPIKey),
+			CacheHit:       false,
+			RequestHash:    call.requestHash,
+			DurationMs:     time.Since(call.startTime).Milliseconds(),
+			IsVision:       h.isVisionRequest(call.req),
+			VirtualKeyID:   call.virtualKeyID(),
+		}, call.reservation)
+
+		format.writeError(c, statusCode, &errResp, err)
+		return
+	}
+	defer body.Close()
+
+	// cost is only known once the last chunk arrives, so it goes out as trailers
+	c.Header("Trailer", "X-Cost-Input, X-Cost-Output, X-Cost-Total")
+	c.Header("X-Cache-Status", call.cacheControl.missStatus())
+	c.Header("X-Key-Source", h.getKeySource(call.userAPIKey))
+	format.startStream(c)
+
+	var resp models.GeminiResponse
+	var raw rawStream
+	var streamErr, clientErr error
+	reader := bufio.NewReader(body)
+
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var parsed *models.GeminiResponse
+			if data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:")); ok {
+				var chunk models.GeminiResponse
+				if err := json.Unmarshal(bytes.TrimSpace(data), &chunk); err != nil {
+					log.Printf("failed to parse stream chunk: %v", err)
+				} else if chunk.Error != nil {
+					streamErr = fmt.Errorf("gemini stream error %d: %s", chunk.Error.Code, chunk.Error.Message)
+				} else {
+					mergeChunk(&resp, chunk)
+					raw.add(bytes.TrimSpace(data))
+					parsed = &chunk
+				}
+			}
+
+			// a gone client still gets billed, the rest of the stream is read
+			// for the usage of the final chunk
+			if clientErr == nil {
+				clientErr = format.writeStreamChunk(c, line, parsed)
+			}
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				streamErr = readErr
+			}
+			break
+		}
+	}
+
+	if clientErr != nil && streamErr == nil {
+		streamErr = fmt.Errorf("client disconnected: %w", clientErr)
+	}
+	format.endStream(c)
+	resp.Raw = raw.body(&resp)
+
+	duration := time.Since(call.startTime)
+	cost := h.calculateCost(resp.UsageMetadata, call.modelCost)
+
+	c.Writer.Header().Set("X-Cost-Input", fmt.Sprintf("%.6f", cost.Input))
+	c.Writer.Header().Set("X-Cost-Output", fmt.Sprintf("%.6f", cost.Output))
+	c.Writer.Header().Set("X-Cost-Total", fmt.Sprintf("%.6f", cost.Total))
+
+	success := streamErr == nil && len(resp.Candidates) > 0
+	var errorMsg string
+	if streamErr != nil {
+		errorMsg = streamErr.Error()
+		log.Printf("gemini stream error: %v", streamErr)
+	} else if success && call.cacheEnabled {
+		h.cacheResponse(c.Request.Context(), cfg, call, &resp)
+	}
+
+	h.logAsync(&store.RequestLog{
+		Timestamp:      time.Now(),
+		Model:          call.model,
+		RequestedModel: call.requestedModel,
+		Request:        call.req,
+		Response:       &resp,
+		RawResponse:    resp.Raw,
+		StatusCode:     http.StatusOK,
+		Success:        success,
+		Error:          errorMsg,
+		Cost:           cost,
+		Spent:          cost.Total,
+		Temperature:    call.temp,
+		KeySource:      h.getKeySource(call.userAPIKey),
+		CacheHit:       false,
+		RequestHash:    call.requestHash,
+		DurationMs:     duration.Milliseconds(),
+		PromptTokens:   resp.UsageMetadata.PromptTokenCount,
+		OutputTokens:   resp.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:    resp.UsageMetadata.TotalTokenCount,
+		IsVision:       h.isVisionRequest(call.req),
+		VirtualKeyID:   call.virtualKeyID(),
+	}, call.reservation)
+}
+
+// mergeChunk folds a streamed chunk into the assembled response. candidates
+// are matched by index, a chunk may carry any of them. consecutive text parts
+// are concatenated (thoughts separately from the answer), other parts
+// appended, and metadata and usage taken from the latest chunk that has them
+func mergeChunk(resp *models.GeminiResponse, chunk models.GeminiResponse) {
+	for _, cand := range chunk.Candidates {
+		i := slices.IndexFunc(resp.Candidates, func(c models.Candidate) bool { return c.Index == cand.Index })
+		if i < 0 {
+			resp.Candidates = append(resp.Candidates, models.Candidate{Index: cand.Index})
+			i = len(resp.Candidates) - 1
+		}
+		dst := &resp.Candidates[i]
+
+		if cand.Content.Role != "" {
+			dst.Content.Role = cand.Content.Role
+		}
+		for _, part := range cand.Content.Parts {
+			last := len(dst.Content.Parts) - 1
+			if part.IsText() && last >= 0 && dst.Content.Parts[last].IsText() &&
+				dst.Content.Parts[last].Thought == part.Thought {
+				dst.Content.Parts[last].Text += part.Text
+				continue
+			}
+			dst.Content.Parts = append(dst.Content.Parts, part)
+		}
+		if cand.FinishReason != "" {
+			dst.FinishReason = cand.FinishReason
+		}
+		if cand.FinishMessage != "" {
+			dst.FinishMessage = cand.FinishMessage
+		}
+		if cand.TokenCount > 0 {
+			dst.TokenCount = cand.TokenCount
+		}
+		if cand.AvgLogprobs != 0 {
+			dst.AvgLogprobs = cand.AvgLogprobs
+		}
+		if len(cand.SafetyRatings) > 0 {
+			dst.SafetyRatings = cand.SafetyRatings
+		}
+		if len(cand.CitationMetadata) > 0 {
+			dst.CitationMetadata = cand.CitationMetadata
+		}
+		if len(cand.GroundingMetadata) > 0 {
+			dst.GroundingMetadata = cand.GroundingMetadata
+		}
+		if len(cand.URLContextMetadata) > 0 {
+			dst.URLContextMetadata = cand.URLContextMetadata
+		}
+		if len(cand.LogprobsResult) > 0 {
+			dst.LogprobsResult = cand.LogprobsResult
+		}
+	}
+
+	if len(chunk.PromptFeedback) > 0 {
+		resp.PromptFeedback = chunk.PromptFeedback
+	}
+	if chunk.ModelVersion != "" {
+		resp.ModelVersion = chunk.ModelVersion
+	}
+	if chunk.ResponseID != "" {
+		resp.ResponseID = chunk.ResponseID
+	}
+	if chunk.UsageMetadata.TotalTokenCount > 0 {
+		resp.UsageMetadata = chunk.UsageMetadata
+	}
+}
+
+// rawStream merges the raw chunks of a stream into one upstream body, so
+// fields the typed response doesn't know survive caching. top-level and
+// candidate fields are taken from the latest chunk that has them, candidates
+// matched by index like mergeChunk does, contents from the assembled response
+type rawStream struct {
+	top        map[string]json.RawMessage
+	candidates []map[string]json.RawMessage
+	indexes    []int // index of each candidate
+}
+
+func (r *rawStream) add(data []byte) {
+	var chunk map[string]json.RawMessage
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return
+	}
+	if r.top == nil {
+		r.top = map[string]json.RawMessage{}
+	}
+
+	for key, value := range chunk {
+		if key != "candidates" {
+			r.top[key] = value
+			continue
+		}
+		var candidates []map[string]json.RawMessage
+		if err := json.Unmarshal(value, &candidates); err != nil {
+			continue
+		}
+		for _, cand := range candidates {
+			var index int
+			json.Unmarshal(cand["index"], &index) // absent means 0
+			i := slices.Index(r.indexes, index)
+			if i < 0 {
+				r.candidates = append(r.candidates, map[string]json.RawMessage{})
+				r.indexes = append(r.indexes, index)
+				i = len(r.candidates) - 1
+			}
+			for k, v := range cand {
+				r.candidates[i][k] = v
+			}
+		}
+	}
+}
+
+// body returns the merged body, nil if no chunk parsed
+func (r *rawStream) body(resp *models.GeminiResponse) json.RawMessage {
+	if r.top == nil {
+		return nil
+	}
+	for i, cand := range r.candidates {
+		j := slices.IndexFunc(resp.Candidates, func(c models.Candidate) bool { return c.Index == r.indexes[i] })
+		if j >= 0 {
+			cand["content"], _ = json.Marshal(resp.Candidates[j].Content)
+		}
+	}
+	if len(r.candidates) > 0 {
+		r.top["candidates"], _ = json.Marshal(r.candidates)
+	}
+	data, err := json.Marshal(r.top)
+	if err != nil {
+		return nil
+	}
+	return data
+}
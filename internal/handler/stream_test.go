@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/client"
+	"ai-wrap/internal/config"
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRawStreamKeepsUnknownFields(t *testing.T) {
+	chunks := []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"hel"}]},"newCandidateField":1}],"newTopField":"a"}`,
+		`{"candidates":[{"content":{"parts":[{"text":"lo"}]},"finishReason":"STOP"}],"usageMetadata":{"totalTokenCount":3},"newTopField":"b"}`,
+	}
+
+	var resp models.GeminiResponse
+	var raw rawStream
+	for _, data := range chunks {
+		var chunk models.GeminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		mergeChunk(&resp, chunk)
+		raw.add([]byte(data))
+	}
+
+	var body struct {
+		Candidates []struct {
+			Content           models.Content `json:"content"`
+			FinishReason      string         `json:"finishReason"`
+			NewCandidateField int            `json:"newCandidateField"`
+		} `json:"candidates"`
+		UsageMetadata models.UsageMetadata `json:"usageMetadata"`
+		NewTopField   string               `json:"newTopField"`
+	}
+	if err := json.Unmarshal(raw.body(&resp), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.NewTopField != "b" || body.UsageMetadata.TotalTokenCount != 3 {
+		t.Errorf("expected top-level fields from the latest chunk, got %+v", body)
+	}
+	if len(body.Candidates) != 1 {
+		t.Fatalf("expected one candidate, got %d", len(body.Candidates))
+	}
+	cand := body.Candidates[0]
+	if cand.NewCandidateField != 1 || cand.FinishReason != "STOP" {
+		t.Errorf("expected candidate fields from every chunk, got %+v", cand)
+	}
+	if len(cand.Content.Parts) != 1 || cand.Content.Parts[0].Text != "hello" || cand.Content.Role != "model" {
+		t.Errorf("expected the assembled content, got %+v", cand.Content)
+	}
+
+	if (&rawStream{}).body(&resp) != nil {
+		t.Error("expected no body without chunks")
+	}
+}
+
+func TestMergeChunkMatchesCandidatesByIndex(t *testing.T) {
+	chunks := []string{
+		`{"candidates":[{"index":1,"content":{"parts":[{"text":"b1"}]},"seen":"b"}]}`,
+		`{"candidates":[{"content":{"parts":[{"text":"a1"}]}}]}`,
+		`{"candidates":[{"index":1,"content":{"parts":[{"text":"b2"}]},"finishReason":"STOP"},{"content":{"parts":[{"text":"a2"}]}}]}`,
+	}
+
+	var resp models.GeminiResponse
+	var raw rawStream
+	for _, data := range chunks {
+		var chunk models.GeminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		mergeChunk(&resp, chunk)
+		raw.add([]byte(data))
+	}
+
+	texts := map[int]string{}
+	for _, cand := range resp.Candidates {
+		texts[cand.Index] = cand.Content.Parts[0].Text
+	}
+	if len(resp.Candidates) != 2 || texts[0] != "a1a2" || texts[1] != "b1b2" {
+		t.Fatalf("expected candidates assembled by index, got %+v", resp.Candidates)
+	}
+
+	var body struct {
+		Candidates []struct {
+			Index        int            `json:"index"`
+			Content      models.Content `json:"content"`
+			FinishReason string         `json:"finishReason"`
+			Seen         string         `json:"seen"`
+		} `json:"candidates"`
+	}
+	if err := json.Unmarshal(raw.body(&resp), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, cand := range body.Candidates {
+		want := map[int]string{0: "a1a2", 1: "b1b2"}[cand.Index]
+		if cand.Content.Parts[0].Text != want {
+			t.Errorf("candidate %d got content %+v, want %q", cand.Index, cand.Content, want)
+		}
+		if cand.Index == 1 && (cand.Seen != "b" || cand.FinishReason != "STOP") {
+			t.Errorf("expected candidate 1 fields from its own chunks, got %+v", cand)
+		}
+	}
+}
+
+// brokenWriter fails every write after the first, like a client that went away
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *brokenWriter) Write(b []byte) (int, error) {
+	if w.writes++; w.writes > 1 {
+		return 0, errors.New("broken pipe")
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestStreamBillsDisconnectedClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"he\"}]}}]}\r\n\r\n"))
+		w.Write([]byte("data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"llo\"}]}}]}\r\n\r\n"))
+		w.Write([]byte("data: {\"candidates\":[{\"finishReason\":\"STOP\"}],\"usageMetadata\":{\"promptTokenCount\":1000000,\"totalTokenCount\":1000000}}\r\n\r\n"))
+	}))
+	defer srv.Close()
+
+	logStore, err := store.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+
+	cfg := &config.Config{Gemini: config.GeminiConfig{APIURL: srv.URL, Timeout: 5}}
+	h := &ProxyHandler{store: logStore, client: client.NewGeminiClient(config.NewHolder("", cfg), nil)}
+	call := proxyCall{model: "m", chain: []string{"m"}, userAPIKey: "user-key", stream: true, startTime: time.Now(),
+		modelCost: config.ModelCost{Rates: config.Rates{Input: 2}}}
+
+	w := &brokenWriter{ResponseRecorder: httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	h.handleStream(c, cfg, call, geminiFormat{})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		logs, _ := logStore.FindPaginated(context.Background(), 0, 1)
+		if len(logs) == 1 {
+			if logs[0].Spent != 2 || logs[0].Success {
+				t.Errorf("expected the disconnected stream billed from its final usage, got %+v", logs[0])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream was never logged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"ai-wrap/internal/client"
+	"ai-wrap/internal/models"
+)
+
+const tokenCacheSize = 1000
+
+// tokenCounter wraps upstream countTokens with a small in-memory cache keyed
+// on the prompt hash, so repeated prompts don't cost an extra round trip
+type tokenCounter struct {
+	client  *client.GeminiClient
+	mu      sync.Mutex
+	counts  map[string][]models.ModalityTokenCount
+	order   []string
+	maxSize int
+}
+
+func newTokenCounter(geminiClient *client.GeminiClient) *tokenCounter {
+	return &tokenCounter{
+		client:  geminiClient,
+		counts:  make(map[string][]models.ModalityTokenCount),
+		maxSize: tokenCacheSize,
+	}
+}
+
+// Count returns the prompt tokens per modality
+func (t *tokenCounter) Count(model string, req models.GeminiRequest, userAPIKey string) ([]models.ModalityTokenCount, error) {
+	key := promptHash(model, req)
+
+	t.mu.Lock()
+	count, ok := t.counts[key]
+	t.mu.Unlock()
+	if ok {
+		return count, nil
+	}
+
+	resp, _, err := t.client.CountTokens(model, models.CountTokensRequest{
+		GenerateContentRequest: &models.GenerateContentRequest{
+			Model:         "models/" + model,
+			GeminiRequest: req,
+		},
+	}, userAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	count = modalityCounts(resp.PromptTokensDetails, resp.TotalTokens)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.counts[key]; !exists {
+		// evict oldest entries first
+		if len(t.order) >= t.maxSize {
+			delete(t.counts, t.order[0])
+			t.order = t.order[1:]
+		}
+		t.order = append(t.order, key)
+	}
+	t.counts[key] = count
+
+	return count, nil
+}
+
+// promptHash covers only what affects the token count
+func promptHash(model string, req models.GeminiRequest) string {
+	data, _ := json.Marshal(struct {
+		Contents          []models.Content
+		SystemInstruction *models.Content
+		Tools             json.RawMessage
+	}{req.Contents, req.SystemInstruction, req.Tools})
+	hash := sha256.Sum256(append([]byte(model+"\x00"), data...))
+	return hex.EncodeToString(hash[:])
+}
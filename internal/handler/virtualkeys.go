@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"ai-wrap/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type virtualKeyRequest struct {
+	Name          *string  `json:"name"`
+	Team          *string  `json:"team"`
+	Project       *string  `json:"project"`
+	DailyBudget   *float64 `json:"daily_budget"`
+	MonthlyBudget *float64 `json:"monthly_budget"`
+	Active        *bool    `json:"active"`
+}
+
+type VirtualKeyInfo struct {
+	store.VirtualKey
+	Spend store.VirtualKeySpend `json:"spend"`
+}
+
+func (h *AdminHandler) ListVirtualKeys(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys, err := h.store.ListVirtualKeys(ctx)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	infos := make([]VirtualKeyInfo, 0, len(keys))
+	for _, key := range keys {
+		spend, err := h.store.GetVirtualKeySpend(ctx, key.ID, now)
+		if err != nil {
+			c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		infos = append(infos, VirtualKeyInfo{VirtualKey: key, Spend: spend})
+	}
+
+	c.JSON(http.StatusOK, infos)
+}
+
+// CreateVirtualKey issues a new key. the token is only ever returned here
+func (h *AdminHandler) CreateVirtualKey(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var req virtualKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == nil || *req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+		return
+	}
+
+	key, token := store.NewVirtualKey()
+	applyVirtualKeyRequest(key, req)
+	if key.DailyBudget < 0 || key.MonthlyBudget < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "budgets must not be negative"})
+		return
+	}
+
+	if err := h.store.CreateVirtualKey(ctx, key); err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"key":   key,
+		"token": token,
+	})
+}
+
+func (h *AdminHandler) UpdateVirtualKey(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var req virtualKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := h.store.FindVirtualKey(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if key == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	applyVirtualKeyRequest(key, req)
+	if key.DailyBudget < 0 || key.MonthlyBudget < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "budgets must not be negative"})
+		return
+	}
+
+	if err := h.store.UpdateVirtualKey(ctx, key); err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// DeleteVirtualKey only deactivates, so spend history stays attributable
+func (h *AdminHandler) DeleteVirtualKey(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := h.store.FindVirtualKey(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if key == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	key.Active = false
+	if err := h.store.UpdateVirtualKey(ctx, key); err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func applyVirtualKeyRequest(key *store.VirtualKey, req virtualKeyRequest) {
+	if req.Name != nil {
+		key.Name = *req.Name
+	}
+	if req.Team != nil {
+		key.Team = *req.Team
+	}
+	if req.Project != nil {
+		key.Project = *req.Project
+	}
+	if req.DailyBudget != nil {
+		key.DailyBudget = *req.DailyBudget
+	}
+	if req.MonthlyBudget != nil {
+		key.MonthlyBudget = *req.MonthlyBudget
+	}
+	if req.Active != nil {
+		key.Active = *req.Active
+	}
+}
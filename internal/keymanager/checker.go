@@ -0,0 +1,212 @@
+package keymanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-wrap/internal/config"
+)
+
+const checkConcurrency = 4
+
+// Checker probes keys against the configured models using countTokens, which
+// is free and still requires the key to have access to the model
+type Checker struct {
+	cfg    *config.Holder
+	client *http.Client
+}
+
+type CheckResult struct {
+	Valid         bool              `json:"valid"`
+	Rejected      bool              `json:"rejected"` // upstream refused the key itself
+	WorkingModels []string          `json:"working_models"`
+	Failures      map[string]string `json:"failures,omitempty"`
+	// Inconclusive are failed models that say nothing about the key: rate
+	// limits, 5xx and network errors
+	Inconclusive []string  `json:"inconclusive,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// Conclusive reports whether any probe said something about the key, a
+// result without it is not worth storing
+func (r CheckResult) Conclusive() bool {
+	return r.Valid || r.Rejected || len(r.Failures) > len(r.Inconclusive)
+}
+
+// Models is what to store as the key's working models: the ones that answered
+// plus the previously working ones whose probe was inconclusive
+func (r CheckResult) Models(previous []string) []string {
+	models := append([]string{}, r.WorkingModels...)
+	for _, model := range r.Inconclusive {
+		if slices.Contains(previous, model) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+func NewChecker(cfg *config.Holder) *Checker {
+	return &Checker{
+		cfg:    cfg,
+		client: &http.Client{},
+	}
+}
+
+// Check probes every configured model. a key is valid when at least one model
+// accepts it. only a refusal (401/403/404) drops a model, rate limits, 5xx
+// and network errors leave it as it was
+func (ch *Checker) Check(ctx context.Context, key string) CheckResult {
+	result := CheckResult{
+		WorkingModels: []string{},
+		Failures:      map[string]string{},
+	}
+
+	for _, model := range ch.cfg.Get().Costs.Models {
+		statusCode, err := ch.probe(ctx, key, model.Name)
+		switch {
+		case err == nil:
+			result.WorkingModels = append(result.WorkingModels, model.Name)
+		case modelRefused(statusCode, err):
+			result.Failures[model.Name] = err.Error()
+			if keyRejected(statusCode, err) {
+				result.Rejected = true
+			}
+		default:
+			result.Failures[model.Name] = err.Error()
+			result.Inconclusive = append(result.Inconclusive, model.Name)
+		}
+	}
+
+	result.Valid = len(result.WorkingModels) > 0
+	if result.Valid {
+		result.Rejected = false
+	}
+	result.CheckedAt = time.Now().UTC().Truncate(time.Second)
+	return result
+}
+
+func (ch *Checker) probe(ctx context.Context, key, model string) (int, error) {
+	url := fmt.Sprintf("%s/models/%s:countTokens?key=%s", ch.cfg.Get().Gemini.APIURL, model, key)
+	body, _ := json.Marshal(map[string]any{
+		"contents": []map[string]any{{"parts": []map[string]string{{"text": "ping"}}}},
+	})
+
+	// read per probe, so a reloaded gemini.timeout applies to the next check
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ch.cfg.Get().Gemini.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("gemini api returned %d: %s", resp.StatusCode, bytes.TrimSpace(bodyBytes))
+	}
+
+	return resp.StatusCode, nil
+}
+
+// modelRefused reports whether upstream definitively refused the key for a
+// model, either the key itself or access to the model
+func modelRefused(statusCode int, err error) bool {
+	return statusCode == http.StatusNotFound || keyRejected(statusCode, err)
+}
+
+func keyRejected(statusCode int, err error) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(err.Error(), "API_KEY_INVALID")
+	}
+	return false
+}
+
+// CheckAll probes every key in the pool, a few at a time
+func (km *KeyManager) CheckAll(ctx context.Context, checker *Checker) map[string]CheckResult {
+	keys := km.List()
+	results := make(map[string]CheckResult, len(keys))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, checkConcurrency)
+
+	for _, key := range keys {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := checker.Check(ctx, value)
+			mu.Lock()
+			results[value] = result
+			mu.Unlock()
+		}(key.Value)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// ApplyChecks records check results in one csv write. rejected keys are
+// deactivated; valid inactive keys are only reactivated when reactivate is set,
+// so keys disabled by hand stay disabled by default
+func (km *KeyManager) ApplyChecks(results map[string]CheckResult, reactivate bool) error {
+	return km.update(func(all []Key) ([]Key, error) {
+		for i := range all {
+			result, ok := results[all[i].Value]
+			if !ok || !result.Conclusive() {
+				continue // inconclusive, keep what we knew
+			}
+			all[i].WorkingModels = joinModels(result.Models(all[i].Models()))
+			all[i].CheckedAt = result.CheckedAt
+			if result.Rejected {
+				all[i].Active = false
+			} else if reactivate {
+				all[i].Active = true
+			}
+		}
+		return all, nil
+	})
+}
+
+// StartHealthCheck re-checks the whole pool every interval until ctx is done
+func (km *KeyManager) StartHealthCheck(ctx context.Context, checker *Checker, interval time.Duration, reactivate bool) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				results := km.CheckAll(ctx, checker)
+				if err := km.ApplyChecks(results, reactivate); err != nil {
+					log.Printf("failed to apply key health check: %v", err)
+					continue
+				}
+				log.Printf("key health check done: %d keys checked, %d active", len(results), km.ActiveCount())
+			}
+		}
+	}()
+}
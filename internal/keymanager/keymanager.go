@@ -1,9 +1,14 @@
 package keymanager
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -28,23 +33,54 @@ var modelPriority = []string{
 }
 
 type Key struct {
-	Value         string    `csv:"key"`
-	Provider      string    `csv:"provider"`
-	Active        bool      `csv:"active"`
-	WorkingModels string    `csv:"working_models"`
-	CheckedAt     time.Time `csv:"checked_at"`
-	priority      int       // computed, not from csv
+	Value         string          `csv:"key"`
+	Provider      string          `csv:"provider"`
+	Active        bool            `csv:"active"`
+	WorkingModels string          `csv:"working_models"`
+	CheckedAt     time.Time       `csv:"checked_at"`
+	priority      int             // computed, not from csv
+	models        map[string]bool // computed, not from csv
+}
+
+// ID is a stable, non-secret identifier for the key
+func (k Key) ID() string {
+	hash := sha256.Sum256([]byte(k.Value))
+	return hex.EncodeToString(hash[:6])
+}
+
+// Masked shows just enough of the key to recognize it
+func (k Key) Masked() string {
+	if len(k.Value) <= 8 {
+		return strings.Repeat("*", len(k.Value))
+	}
+	return k.Value[:4] + "..." + k.Value[len(k.Value)-4:]
+}
+
+// Models returns working models without the "models/" prefix
+func (k Key) Models() []string {
+	if k.WorkingModels == "" {
+		return []string{}
+	}
+	models := strings.Split(k.WorkingModels, "|")
+	for i, model := range models {
+		models[i] = strings.TrimPrefix(model, "models/")
+	}
+	return models
 }
 
 type KeyManager struct {
-	keys    []Key
-	mu      sync.RWMutex
-	csvPath string
+	all       []Key // every csv row, in file order
+	keys      []Key // active keys, sorted by priority
+	cooldowns map[string]time.Time
+	mu        sync.RWMutex
+	writeMu   sync.Mutex // serializes csv rewrites
+	csvPath   string
 }
 
 func New(csvPath string) (*KeyManager, error) {
 	km := &KeyManager{
-		csvPath: csvPath,
+		csvPath:   csvPath,
+		cooldowns: make(map[string]time.Time),
 	}
 
 	if err := km.loadKeys(); err != nil {
@@ -75,92 +111,195 @@ func getBestPriority(workingModels string) int {
 	return best
 }
 
-func (km *KeyManager) loadKeys() error {
-	file, err := os.Open(km.csvPath)
+// ReadKeys parses and validates a keys csv without loading it into a pool
+func ReadKeys(csvPath string) ([]Key, error) {
+	file, err := os.Open(csvPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
-	var allKeys []Key
-	if err := gocsv.UnmarshalFile(file, &allKeys); err != nil {
+	var keys []Key
+	if err := gocsv.UnmarshalFile(file, &keys); err != nil {
+		return nil, err
+	}
+	if err := Validate(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Validate checks csv rows: every row needs a key and each key may appear once,
+// since keys are addressed by value and by ID
+func Validate(keys []Key) error {
+	var errs []error
+	seen := map[string]int{}
+	for i, key := range keys {
+		row := i + 2 // 1-based, after the header
+		if strings.TrimSpace(key.Value) == "" {
+			errs = append(errs, fmt.Errorf("row %d: key is empty", row))
+			continue
+		}
+		if first, dup := seen[key.Value]; dup {
+			errs = append(errs, fmt.Errorf("row %d: duplicate of key on row %d (%s)", row, first, key.Masked()))
+			continue
+		}
+		seen[key.Value] = row
+	}
+	return errors.Join(errs...)
+}
+
+func (km *KeyManager) loadKeys() error {
+	allKeys, err := ReadKeys(km.csvPath)
+	if err != nil {
 		return err
 	}
 
+	km.mu.Lock()
+	km.all = allKeys
+	km.rebuild()
+	km.mu.Unlock()
+
+	return nil
+}
+
+// Reload re-reads the csv after an outside edit. cooldowns are kept since they
+// are tracked by key value. a file that fails to parse leaves the pool as is
+func (km *KeyManager) Reload() error {
+	km.writeMu.Lock()
+	defer km.writeMu.Unlock()
+
+	if err := km.loadKeys(); err != nil {
+		return fmt.Errorf("keeping current keys: %w", err)
+	}
+	log.Printf("reloaded %s: %d active keys", km.csvPath, km.ActiveCount())
+	return nil
+}
+
+// rebuild recomputes the rotation from all keys. callers must hold mu
+func (km *KeyManager) rebuild() {
 	var activeKeys []Key
-	for _, key := range allKeys {
+	for _, key := range km.all {
 		if key.Active {
 			key.priority = getBestPriority(key.WorkingModels)
+			key.models = make(map[string]bool)
+			for _, model := range key.Models() {
+				key.models[model] = true
+			}
 			activeKeys = append(activeKeys, key)
 		}
 	}
 
 	// sort by priority (lower = better)
-	sort.Slice(activeKeys, func(i, j int) bool {
+	sort.SliceStable(activeKeys, func(i, j int) bool {
 		return activeKeys[i].priority < activeKeys[j].priority
 	})
 
-	km.mu.Lock()
 	km.keys = activeKeys
-	km.mu.Unlock()
+}
 
-	return nil
+// DefaultCooldown applies when a rate limited response carries no retry hint
+const DefaultCooldown = 60 * time.Second
+
+func (km *KeyManager) GetKey(model string) string {
+	return km.RotateKey(model, nil)
 }
 
-func (km *KeyManager) GetKey() string {
+// RotateKey returns the best key for model that is neither cooling down nor
+// already tried. keys known to serve the model come first, then keys that were
+// never checked, and only then any other key
+func (km *KeyManager) RotateKey(model string, tried map[string]bool) string {
 	km.mu.RLock()
 	defer km.mu.RUnlock()
 
-	if len(km.keys) == 0 {
-		return ""
-	}
-
-	// find all keys with the best (lowest) priority
-	bestPriority := km.keys[0].priority
+	now := time.Now()
+	bestTier := -1
 	var bestKeys []Key
 	for _, k := range km.keys {
-		if k.priority == bestPriority {
+		if tried[k.Value] || km.cooldowns[k.Value].After(now) {
+			continue
+		}
+
+		tier := modelTier(k, model)
+		switch {
+		case bestTier == -1 || tier < bestTier:
+			bestTier = tier
+			bestKeys = []Key{k}
+		case tier == bestTier && k.priority == bestKeys[0].priority:
+			// keys are sorted by priority, so the first match per tier is the best
 			bestKeys = append(bestKeys, k)
-		} else {
-			break // already sorted, no need to continue
 		}
 	}
 
+	if len(bestKeys) == 0 {
+		return ""
+	}
+
 	// random among best keys
 	idx := rand.Intn(len(bestKeys))
 	return bestKeys[idx].Value
 }
 
-func (km *KeyManager) RotateKey(failedKey string) string {
+// modelTier ranks how well a key fits the model: 0 listed, 1 unchecked, 2 other
+func modelTier(k Key, model string) int {
+	switch {
+	case model == "" || k.models[model]:
+		return 0
+	case len(k.models) == 0:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Cooldown takes a key out of rotation until d has passed. it returns on its
+// own once the cooldown expires
+func (km *KeyManager) Cooldown(key string, d time.Duration) {
 	km.mu.Lock()
 	defer km.mu.Unlock()
 
-	filtered := make([]Key, 0, len(km.keys))
-	for _, key := range km.keys {
-		if key.Value != failedKey {
-			filtered = append(filtered, key)
+	now := time.Now()
+	for k, until := range km.cooldowns {
+		if !until.After(now) {
+			delete(km.cooldowns, k)
 		}
 	}
 
-	km.keys = filtered
+	until := now.Add(d)
+	if until.After(km.cooldowns[key]) {
+		km.cooldowns[key] = until
+	}
+}
+
+// CooldownUntil returns when the key's cooldown ends, zero if it isn't cooling
+func (km *KeyManager) CooldownUntil(key string) time.Time {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
 
-	if len(km.keys) == 0 {
-		return ""
+	if until := km.cooldowns[key]; until.After(time.Now()) {
+		return until
 	}
+	return time.Time{}
+}
 
-	// return best available key
-	bestPriority := km.keys[0].priority
-	var bestKeys []Key
+// NextAvailable returns the earliest time a cooling key becomes usable again,
+// or the zero time when some key can be used right now
+func (km *KeyManager) NextAvailable() time.Time {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	now := time.Now()
+	var earliest time.Time
 	for _, k := range km.keys {
-		if k.priority == bestPriority {
-			bestKeys = append(bestKeys, k)
-		} else {
-			break
+		until := km.cooldowns[k.Value]
+		if !until.After(now) {
+			return time.Time{}
+		}
+		if earliest.IsZero() || until.Before(earliest) {
+			earliest = until
 		}
 	}
-
-	idx := rand.Intn(len(bestKeys))
-	return bestKeys[idx].Value
+	return earliest
 }
 
 func (km *KeyManager) ActiveCount() int {
@@ -169,45 +308,152 @@ func (km *KeyManager) ActiveCount() int {
 	return len(km.keys)
 }
 
-func (km *KeyManager) MarkInactive(key string) error {
-	file, err := os.Open(km.csvPath)
-	if err != nil {
-		return err
+// List returns a copy of every key, active or not
+func (km *KeyManager) List() []Key {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	keys := make([]Key, len(km.all))
+	copy(keys, km.all)
+	return keys
+}
+
+// InRotation reports whether the key is currently handed out by GetKey
+func (km *KeyManager) InRotation(key string) bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	if km.cooldowns[key].After(time.Now()) {
+		return false
+	}
+	for _, k := range km.keys {
+		if k.Value == key {
+			return true
+		}
 	}
+	return false
+}
 
-	var allKeys []Key
-	if err := gocsv.UnmarshalFile(file, &allKeys); err != nil {
-		file.Close()
-		return err
+// Find looks a key up by its ID
+func (km *KeyManager) Find(id string) (Key, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	for _, k := range km.all {
+		if k.ID() == id {
+			return k, true
+		}
 	}
-	file.Close()
+	return Key{}, false
+}
 
-	for i, k := range allKeys {
-		if k.Value == key {
-			allKeys[i].Active = false
-			break
+// Add appends a new active key and persists it
+func (km *KeyManager) Add(key Key) error {
+	key.Value = strings.TrimSpace(key.Value)
+	if key.Value == "" {
+		return fmt.Errorf("key value required")
+	}
+	if key.Provider == "" {
+		key.Provider = "gemini"
+	}
+
+	return km.update(func(all []Key) ([]Key, error) {
+		for _, k := range all {
+			if k.Value == key.Value {
+				return nil, fmt.Errorf("key %s already exists", key.ID())
+			}
 		}
+		return append(all, key), nil
+	})
+}
+
+// SetActive enables or disables a key and persists the change
+func (km *KeyManager) SetActive(key string, active bool) error {
+	return km.modify(key, func(k *Key) {
+		k.Active = active
+	})
+}
+
+// RecordCheck stores the outcome of a health check for a key. models whose
+// probe was inconclusive keep their previous state
+func (km *KeyManager) RecordCheck(key string, result CheckResult) error {
+	return km.modify(key, func(k *Key) {
+		k.WorkingModels = joinModels(result.Models(k.Models()))
+		k.CheckedAt = result.CheckedAt
+	})
+}
+
+// joinModels formats models the way the csv stores them
+func joinModels(models []string) string {
+	prefixed := make([]string, len(models))
+	for i, model := range models {
+		prefixed[i] = "models/" + model
 	}
+	return strings.Join(prefixed, "|")
+}
+
+func (km *KeyManager) MarkInactive(key string) error {
+	return km.SetActive(key, false)
+}
+
+func (km *KeyManager) modify(key string, change func(k *Key)) error {
+	return km.update(func(all []Key) ([]Key, error) {
+		for i := range all {
+			if all[i].Value == key {
+				change(&all[i])
+				return all, nil
+			}
+		}
+		return nil, fmt.Errorf("key not found")
+	})
+}
+
+// update applies change to a copy of all keys, persists the result and only
+// then swaps it in. writeMu keeps concurrent updates from interleaving
+func (km *KeyManager) update(change func(all []Key) ([]Key, error)) error {
+	km.writeMu.Lock()
+	defer km.writeMu.Unlock()
+
+	km.mu.RLock()
+	all := make([]Key, len(km.all))
+	copy(all, km.all)
+	km.mu.RUnlock()
 
-	outFile, err := os.Create(km.csvPath)
+	all, err := change(all)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
 
-	if err := gocsv.MarshalFile(&allKeys, outFile); err != nil {
+	if err := km.writeKeys(all); err != nil {
 		return err
 	}
 
 	km.mu.Lock()
-	filtered := make([]Key, 0, len(km.keys))
-	for _, k := range km.keys {
-		if k.Value != key {
-			filtered = append(filtered, k)
-		}
-	}
-	km.keys = filtered
+	km.all = all
+	km.rebuild()
 	km.mu.Unlock()
 
 	return nil
 }
+
+// writeKeys replaces the csv atomically via a temp file in the same directory
+func (km *KeyManager) writeKeys(keys []Key) error {
+	tmp, err := os.CreateTemp(filepath.Dir(km.csvPath), ".keys-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gocsv.MarshalFile(&keys, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(km.csvPath); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+
+	return os.Rename(tmp.Name(), km.csvPath)
+}
@@ -0,0 +1,193 @@
+package keymanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/config"
+
+	"github.com/gocarina/gocsv"
+)
+
+func newTestKeyManager(t *testing.T, keys []Key) *KeyManager {
+	path := filepath.Join(t.TempDir(), "keys.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gocsv.MarshalFile(&keys, file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	km, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to load keys: %v", err)
+	}
+	return km
+}
+
+func TestRotateKeyOrder(t *testing.T) {
+	keys := []Key{
+		{Value: "flash", Provider: "gemini", Active: true, WorkingModels: "models/gemini-2.5-flash"},
+		{Value: "pro", Provider: "gemini", Active: true, WorkingModels: "models/gemini-2.5-pro|models/gemini-2.5-flash"},
+		{Value: "unchecked", Provider: "gemini", Active: true},
+		{Value: "lite", Provider: "gemini", Active: true, WorkingModels: "models/gemini-2.0-flash-lite"},
+		{Value: "off", Provider: "gemini", Active: false, WorkingModels: "models/gemini-3-pro-preview"},
+	}
+
+	tests := []struct {
+		name  string
+		model string
+		tried []string
+		want  string
+	}{
+		{"any model takes the best priority", "", nil, "pro"},
+		{"listed key first", "gemini-2.0-flash-lite", nil, "lite"},
+		{"best priority among listed keys", "gemini-2.5-flash", nil, "pro"},
+		{"next listed key once tried", "gemini-2.5-flash", []string{"pro"}, "flash"},
+		{"unchecked before other keys", "gemini-2.0-flash", nil, "unchecked"},
+		{"unchecked once listed keys are tried", "gemini-2.5-flash", []string{"pro", "flash"}, "unchecked"},
+		{"other keys last", "gemini-2.0-flash", []string{"unchecked"}, "pro"},
+		{"inactive keys never", "gemini-3-pro-preview", []string{"pro", "flash", "unchecked", "lite"}, ""},
+	}
+
+	km := newTestKeyManager(t, keys)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tried := map[string]bool{}
+			for _, k := range tt.tried {
+				tried[k] = true
+			}
+			if got := km.RotateKey(tt.model, tried); got != tt.want {
+				t.Errorf("RotateKey(%q) = %q, want %q", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCooldownExpiry(t *testing.T) {
+	km := newTestKeyManager(t, []Key{
+		{Value: "a", Provider: "gemini", Active: true},
+		{Value: "b", Provider: "gemini", Active: true},
+	})
+
+	km.Cooldown("a", time.Hour)
+	if got := km.GetKey(""); got != "b" {
+		t.Errorf("expected b while a cools down, got %q", got)
+	}
+	if !km.NextAvailable().IsZero() {
+		t.Error("expected no wait while b is available")
+	}
+
+	km.Cooldown("b", 50*time.Millisecond)
+	if got := km.GetKey(""); got != "" {
+		t.Errorf("expected no key while both cool down, got %q", got)
+	}
+	if until := km.NextAvailable(); until.IsZero() || until.After(time.Now().Add(time.Minute)) {
+		t.Errorf("expected b's cooldown end, got %v", until)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got := km.GetKey(""); got != "b" {
+		t.Errorf("expected b back after its cooldown, got %q", got)
+	}
+	if !km.CooldownUntil("b").IsZero() || !km.InRotation("b") {
+		t.Error("expected b's cooldown to be over")
+	}
+	if km.InRotation("a") {
+		t.Error("expected a to still be cooling down")
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	km := newTestKeyManager(t, []Key{
+		{Value: "first-key-value", Provider: "gemini", Active: true, WorkingModels: "models/gemini-2.5-flash"},
+	})
+
+	checkedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := km.Add(Key{Value: " second-key-value ", Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.Add(Key{Value: "second-key-value"}); err == nil {
+		t.Error("expected a duplicate key to be rejected")
+	}
+	if err := km.RecordCheck("second-key-value", CheckResult{WorkingModels: []string{"gemini-2.5-pro", "gemini-2.5-flash"}, CheckedAt: checkedAt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.SetActive("first-key-value", false); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := New(km.csvPath)
+	if err != nil {
+		t.Fatalf("failed to reload keys: %v", err)
+	}
+
+	want := []Key{
+		{Value: "first-key-value", Provider: "gemini", Active: false, WorkingModels: "models/gemini-2.5-flash"},
+		{Value: "second-key-value", Provider: "gemini", Active: true, WorkingModels: "models/gemini-2.5-pro|models/gemini-2.5-flash", CheckedAt: checkedAt},
+	}
+	got := reloaded.List()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d keys, got %d", len(want), len(got))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Value != w.Value || g.Provider != w.Provider || g.Active != w.Active ||
+			g.WorkingModels != w.WorkingModels || !g.CheckedAt.Equal(w.CheckedAt) {
+			t.Errorf("key %d = %+v, want %+v", i, g, w)
+		}
+	}
+	if reloaded.ActiveCount() != 1 || reloaded.GetKey("gemini-2.5-pro") != "second-key-value" {
+		t.Error("expected only the second key in rotation")
+	}
+}
+
+func TestCheckKeepsInconclusiveModels(t *testing.T) {
+	statuses := map[string]int{
+		"pro":   http.StatusOK,
+		"flash": http.StatusTooManyRequests,
+		"lite":  http.StatusNotFound,
+		"exp":   http.StatusServiceUnavailable,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		model := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/models/"), ":countTokens")
+		w.WriteHeader(statuses[model])
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		Gemini: config.GeminiConfig{APIURL: srv.URL, Timeout: 5},
+		Costs:  config.CostsConfig{Models: []config.ModelConfig{{Name: "pro"}, {Name: "flash"}, {Name: "lite"}, {Name: "exp"}}},
+	}
+	checker := NewChecker(config.NewHolder("", cfg))
+	km := newTestKeyManager(t, []Key{
+		{Value: "a", Provider: "gemini", Active: true, WorkingModels: "models/flash|models/lite"},
+	})
+
+	result := checker.Check(context.Background(), "a")
+	if !result.Valid || result.Rejected || !result.Conclusive() {
+		t.Fatalf("expected a valid result, got %+v", result)
+	}
+	if err := km.ApplyChecks(map[string]CheckResult{"a": result}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := km.List()[0].WorkingModels; got != "models/pro|models/flash" {
+		t.Errorf("expected the rate limited model kept and the refused one dropped, got %q", got)
+	}
+
+	// nothing but rate limits leaves the key untouched
+	statuses["pro"] = http.StatusTooManyRequests
+	statuses["lite"] = http.StatusTooManyRequests
+	if result := checker.Check(context.Background(), "a"); result.Conclusive() {
+		t.Errorf("expected an inconclusive result, got %+v", result)
+	}
+}
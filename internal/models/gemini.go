@@ -1,16 +1,48 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
+// GeminiRequest is the full generateContent body. fields the proxy reads are
+// typed, the rest are kept as raw json so they reach gemini unchanged
 type GeminiRequest struct {
-	Contents         []Content        `json:"contents"`
-	GenerationConfig GenerationConfig `json:"generationConfig,omitempty"`
+	Contents          []Content        `json:"contents"`
+	SystemInstruction *Content         `json:"systemInstruction,omitempty"`
+	Tools             json.RawMessage  `json:"tools,omitempty"`
+	ToolConfig        json.RawMessage  `json:"toolConfig,omitempty"`
+	SafetySettings    json.RawMessage  `json:"safetySettings,omitempty"`
+	CachedContent     string           `json:"cachedContent,omitempty"`
+	GenerationConfig  GenerationConfig `json:"generationConfig,omitempty"`
 }
 
 type GenerationConfig struct {
-	Temperature     *float64 `json:"temperature,omitempty"`
-	TopP            *float64 `json:"topP,omitempty"`
-	TopK            *int     `json:"topK,omitempty"`
-	MaxOutputTokens *int     `json:"maxOutputTokens,omitempty"`
-	CandidateCount  *int     `json:"candidateCount,omitempty"`
+	Temperature                *float64        `json:"temperature,omitempty"`
+	TopP                       *float64        `json:"topP,omitempty"`
+	TopK                       *int            `json:"topK,omitempty"`
+	MaxOutputTokens            *int            `json:"maxOutputTokens,omitempty"`
+	CandidateCount             *int            `json:"candidateCount,omitempty"`
+	StopSequences              []string        `json:"stopSequences,omitempty"`
+	Seed                       *int            `json:"seed,omitempty"`
+	PresencePenalty            *float64        `json:"presencePenalty,omitempty"`
+	FrequencyPenalty           *float64        `json:"frequencyPenalty,omitempty"`
+	ResponseLogprobs           *bool           `json:"responseLogprobs,omitempty"`
+	Logprobs                   *int            `json:"logprobs,omitempty"`
+	ResponseMimeType           string          `json:"responseMimeType,omitempty"`
+	ResponseSchema             json.RawMessage `json:"responseSchema,omitempty"`
+	ResponseJSONSchema         json.RawMessage `json:"responseJsonSchema,omitempty"`
+	ResponseModalities         []string        `json:"responseModalities,omitempty"`
+	MediaResolution            string          `json:"mediaResolution,omitempty"`
+	SpeechConfig               json.RawMessage `json:"speechConfig,omitempty"`
+	EnableEnhancedCivicAnswers *bool           `json:"enableEnhancedCivicAnswers,omitempty"`
+	ThinkingConfig             *ThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type ThinkingConfig struct {
+	IncludeThoughts *bool  `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int   `json:"thinkingBudget,omitempty"`
+	ThinkingLevel   string `json:"thinkingLevel,omitempty"`
 }
 
 type Content struct {
@@ -18,9 +50,26 @@ type Content struct {
 	Role  string `json:"role,omitempty"`
 }
 
+// Part is one piece of content. exactly one of the data fields is set
 type Part struct {
-	Text       string      `json:"text,omitempty"`
-	InlineData *InlineData `json:"inlineData,omitempty"`
+	Text                string            `json:"text,omitempty"`
+	InlineData          *InlineData       `json:"inlineData,omitempty"`
+	FileData            *FileData         `json:"fileData,omitempty"`
+	FunctionCall        *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse    *FunctionResponse `json:"functionResponse,omitempty"`
+	ExecutableCode      json.RawMessage   `json:"executableCode,omitempty"`
+	CodeExecutionResult json.RawMessage   `json:"codeExecutionResult,omitempty"`
+	VideoMetadata       json.RawMessage   `json:"videoMetadata,omitempty"`
+	Thought             bool              `json:"thought,omitempty"`
+	ThoughtSignature    string            `json:"thoughtSignature,omitempty"`
+}
+
+// IsText is true for a plain text part, the only kind that can be merged
+func (p Part) IsText() bool {
+	return p.Text != "" && p.InlineData == nil && p.FileData == nil &&
+		p.FunctionCall == nil && p.FunctionResponse == nil &&
+		p.ExecutableCode == nil && p.CodeExecutionResult == nil &&
+		p.ThoughtSignature == ""
 }
 
 type InlineData struct {
@@ -28,32 +77,141 @@ type InlineData struct {
 	Data     string `json:"data"`
 }
 
+type FileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type FunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type FunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// GeminiResponse is the full generateContent response. like the request,
+// anything the proxy doesn't read is kept as raw json
 type GeminiResponse struct {
-	Candidates    []Candidate   `json:"candidates,omitempty"`
-	UsageMetadata UsageMetadata `json:"usageMetadata,omitempty"`
-	Error         *ErrorDetail  `json:"error,omitempty"`
+	Candidates     []Candidate     `json:"candidates,omitempty"`
+	PromptFeedback json.RawMessage `json:"promptFeedback,omitempty"`
+	UsageMetadata  UsageMetadata   `json:"usageMetadata,omitempty"`
+	ModelVersion   string          `json:"modelVersion,omitempty"`
+	ResponseID     string          `json:"responseId,omitempty"`
+	Error          *ErrorDetail    `json:"error,omitempty"`
+
+	// Raw is the upstream body as received, written back to gemini clients
+	// as is. caches and logs keep it next to the response (cache.Entry.Raw,
+	// store.RequestLog.RawResponse), since it doesn't encode with it
+	Raw json.RawMessage `json:"-" bson:"-"`
 }
 
 type ErrorDetail struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Status  string `json:"status"`
+	Code    int              `json:"code"`
+	Message string           `json:"message"`
+	Status  string           `json:"status"`
+	Details []map[string]any `json:"details,omitempty"`
+}
+
+// RetryDelay reads the google.rpc.RetryInfo hint from error details, if any
+func (e *ErrorDetail) RetryDelay() (time.Duration, bool) {
+	if e == nil {
+		return 0, false
+	}
+	for _, detail := range e.Details {
+		if detail["@type"] != "type.googleapis.com/google.rpc.RetryInfo" {
+			continue
+		}
+		delay, _ := detail["retryDelay"].(string)
+		if d, err := time.ParseDuration(delay); err == nil {
+			return d, true
+		}
+	}
+	return 0, false
 }
 
 type Candidate struct {
-	Content       Content `json:"content"`
-	FinishReason  string  `json:"finishReason,omitempty"`
-	SafetyRatings []any   `json:"safetyRatings,omitempty"`
+	Content            Content         `json:"content"`
+	FinishReason       string          `json:"finishReason,omitempty"`
+	FinishMessage      string          `json:"finishMessage,omitempty"`
+	Index              int             `json:"index,omitempty"`
+	TokenCount         int             `json:"tokenCount,omitempty"`
+	AvgLogprobs        float64         `json:"avgLogprobs,omitempty"`
+	SafetyRatings      []SafetyRating  `json:"safetyRatings,omitempty"`
+	CitationMetadata   json.RawMessage `json:"citationMetadata,omitempty"`
+	GroundingMetadata  json.RawMessage `json:"groundingMetadata,omitempty"`
+	URLContextMetadata json.RawMessage `json:"urlContextMetadata,omitempty"`
+	LogprobsResult     json.RawMessage `json:"logprobsResult,omitempty"`
+}
+
+// SafetyRating is typed, not raw, so logs written before it was a struct
+// still decode from mongodb
+type SafetyRating struct {
+	Category         string  `json:"category"`
+	Probability      string  `json:"probability"`
+	ProbabilityScore float64 `json:"probabilityScore,omitempty"`
+	Severity         string  `json:"severity,omitempty"`
+	SeverityScore    float64 `json:"severityScore,omitempty"`
+	Blocked          bool    `json:"blocked,omitempty"`
 }
 
+// UsageMetadata counts tokens. PromptTokenCount includes the cached tokens,
+// CandidatesTokenCount excludes the thoughts
 type UsageMetadata struct {
-	PromptTokenCount     int `json:"promptTokenCount"`
-	CandidatesTokenCount int `json:"candidatesTokenCount"`
-	TotalTokenCount      int `json:"totalTokenCount"`
+	PromptTokenCount           int                  `json:"promptTokenCount"`
+	CandidatesTokenCount       int                  `json:"candidatesTokenCount"`
+	TotalTokenCount            int                  `json:"totalTokenCount"`
+	CachedContentTokenCount    int                  `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount         int                  `json:"thoughtsTokenCount,omitempty"`
+	ToolUsePromptTokenCount    int                  `json:"toolUsePromptTokenCount,omitempty"`
+	PromptTokensDetails        []ModalityTokenCount `json:"promptTokensDetails,omitempty"`
+	CacheTokensDetails         []ModalityTokenCount `json:"cacheTokensDetails,omitempty"`
+	CandidatesTokensDetails    []ModalityTokenCount `json:"candidatesTokensDetails,omitempty"`
+	ToolUsePromptTokensDetails []ModalityTokenCount `json:"toolUsePromptTokensDetails,omitempty"`
+}
+
+type ModalityTokenCount struct {
+	Modality   string `json:"modality"`
+	TokenCount int    `json:"tokenCount"`
 }
 
 type Cost struct {
 	Input  float64
 	Output float64
 	Total  float64
+	// Breakdown itemizes Input and Output, empty on logs written before it existed
+	Breakdown []CostItem `json:",omitempty" bson:",omitempty"`
+}
+
+// CostItem is one priced line of a Cost. Kind is input, cached_input,
+// tool_use_input, output or thoughts, Modality is the gemini modality (TEXT, AUDIO, ...)
+type CostItem struct {
+	Kind     string
+	Modality string
+	Tokens   int
+	Rate     float64 // usd per 1M tokens
+	Cost     float64
+}
+
+type CountTokensRequest struct {
+	Contents               []Content               `json:"contents,omitempty"`
+	GenerateContentRequest *GenerateContentRequest `json:"generateContentRequest,omitempty"`
+}
+
+// GenerateContentRequest is a GeminiRequest with the model named inline, as
+// countTokens expects it
+type GenerateContentRequest struct {
+	Model string `json:"model"`
+	GeminiRequest
+}
+
+type CountTokensResponse struct {
+	TotalTokens             int                  `json:"totalTokens"`
+	CachedContentTokenCount int                  `json:"cachedContentTokenCount,omitempty"`
+	PromptTokensDetails     []ModalityTokenCount `json:"promptTokensDetails,omitempty"`
+	Error                   *ErrorDetail         `json:"error,omitempty"`
 }
@@ -0,0 +1,82 @@
+package reload
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultInterval is how often watched files are checked for changes
+const DefaultInterval = 2 * time.Second
+
+// File is a watched file and what to do when it changes
+type File struct {
+	Path   string
+	Reload func() error
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stat(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+// Watch reloads a file when its mtime or size changes, and every file on
+// SIGHUP. it polls rather than using inotify so it also works for bind mounts
+// and editors that replace the file
+func Watch(ctx context.Context, interval time.Duration, files ...File) {
+	states := make([]fileState, len(files))
+	for i, f := range files {
+		states[i] = stat(f.Path)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Printf("received SIGHUP, reloading")
+				for i, f := range files {
+					states[i] = stat(f.Path)
+					run(f)
+				}
+			case <-ticker.C:
+				for i, f := range files {
+					state := stat(f.Path)
+					if state == states[i] {
+						continue
+					}
+					states[i] = state
+					if state.exists {
+						run(f)
+					}
+				}
+			}
+		}
+	}()
+}
+
+func run(f File) {
+	if err := f.Reload(); err != nil {
+		log.Printf("failed to reload %s: %v", f.Path, err)
+	}
+}
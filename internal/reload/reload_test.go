@@ -0,0 +1,66 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestWatchReloadsOnChangeAndSIGHUP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("a: 1\n"), 0o644)
+
+	var reloads atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Watch(ctx, 5*time.Millisecond, File{Path: path, Reload: func() error {
+		reloads.Add(1)
+		return nil
+	}})
+
+	time.Sleep(20 * time.Millisecond)
+	if reloads.Load() != 0 {
+		t.Fatalf("expected no reload without a change, got %d", reloads.Load())
+	}
+
+	os.WriteFile(path, []byte("a: 22\n"), 0o644)
+	waitFor(t, "reload after write", func() bool { return reloads.Load() == 1 })
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitFor(t, "reload after SIGHUP", func() bool { return reloads.Load() == 2 })
+}
+
+func TestWatchSkipsMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.csv")
+
+	var reloads atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Watch(ctx, 5*time.Millisecond, File{Path: path, Reload: func() error {
+		reloads.Add(1)
+		return nil
+	}})
+
+	time.Sleep(20 * time.Millisecond)
+	if reloads.Load() != 0 {
+		t.Fatal("expected no reload for a missing file")
+	}
+
+	os.WriteFile(path, []byte("key\n"), 0o644)
+	waitFor(t, "reload once the file appears", func() bool { return reloads.Load() == 1 })
+}
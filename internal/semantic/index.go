@@ -0,0 +1,101 @@
+package semantic
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Index remembers the fingerprints of cached requests and points near
+// duplicates at the request hash their response is cached under. it lives in
+// process and is bounded, the oldest entries are dropped first
+type Index struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List // front = newest
+	byHash     map[string]*list.Element
+	buckets    map[string]map[string]uint64 // bucket → request hash → fingerprint
+}
+
+type indexEntry struct {
+	hash   string
+	bucket string
+}
+
+// Match is the closest indexed request
+type Match struct {
+	Hash       string
+	Similarity float64
+}
+
+func NewIndex(maxEntries int) *Index {
+	return &Index{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		byHash:     make(map[string]*list.Element),
+		buckets:    make(map[string]map[string]uint64),
+	}
+}
+
+// Add indexes the request cached under hash. adding a known hash refreshes it
+func (x *Index) Add(key Key, hash string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if el, ok := x.byHash[hash]; ok {
+		x.removeLocked(el)
+	}
+
+	bucket := x.buckets[key.Bucket]
+	if bucket == nil {
+		bucket = make(map[string]uint64)
+		x.buckets[key.Bucket] = bucket
+	}
+	bucket[hash] = key.Fingerprint
+	x.byHash[hash] = x.order.PushFront(&indexEntry{hash: hash, bucket: key.Bucket})
+
+	for x.maxEntries > 0 && x.order.Len() > x.maxEntries {
+		x.removeLocked(x.order.Back())
+	}
+}
+
+// Lookup returns the most similar request in the same bucket, if any reaches
+// threshold (0 to 1)
+func (x *Index) Lookup(key Key, threshold float64) (Match, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var best Match
+	for hash, fp := range x.buckets[key.Bucket] {
+		similarity := Similarity(key.Fingerprint, fp)
+		if similarity > best.Similarity || (similarity == best.Similarity && hash < best.Hash) {
+			best = Match{Hash: hash, Similarity: similarity}
+		}
+	}
+	return best, best.Hash != "" && best.Similarity >= threshold
+}
+
+// Remove forgets hash, e.g. once its cache entry is gone
+func (x *Index) Remove(hash string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if el, ok := x.byHash[hash]; ok {
+		x.removeLocked(el)
+	}
+}
+
+func (x *Index) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.order.Len()
+}
+
+func (x *Index) removeLocked(el *list.Element) {
+	entry := el.Value.(*indexEntry)
+	x.order.Remove(el)
+	delete(x.byHash, entry.hash)
+	delete(x.buckets[entry.bucket], entry.hash)
+	if len(x.buckets[entry.bucket]) == 0 {
+		delete(x.buckets, entry.bucket)
+	}
+}
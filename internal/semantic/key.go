@@ -0,0 +1,51 @@
+package semantic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"ai-wrap/internal/models"
+	"ai-wrap/internal/store"
+)
+
+// Key places a request in the semantic index. only requests with the same
+// bucket (model, settings, tools, media, roles) are compared, and within it
+// by the fingerprint of their text
+type Key struct {
+	Bucket      string
+	Fingerprint uint64
+}
+
+func KeyOf(model string, req models.GeminiRequest) Key {
+	var text strings.Builder
+	stripped := store.Canonicalize(req)
+	stripped.Contents = stripText(stripped.Contents, &text)
+	if stripped.SystemInstruction != nil {
+		system := stripText([]models.Content{*stripped.SystemInstruction}, &text)
+		stripped.SystemInstruction = &system[0]
+	}
+
+	hash := sha256.Sum256(append([]byte(model+"\x00"), store.CanonicalJSON(stripped)...))
+	return Key{
+		Bucket:      hex.EncodeToString(hash[:]),
+		Fingerprint: Fingerprint(text.String()),
+	}
+}
+
+// stripText copies contents without their text parts, collecting the text
+func stripText(contents []models.Content, text *strings.Builder) []models.Content {
+	out := make([]models.Content, len(contents))
+	for i, content := range contents {
+		out[i] = models.Content{Role: content.Role, Parts: make([]models.Part, len(content.Parts))}
+		for j, part := range content.Parts {
+			if part.IsText() {
+				text.WriteString(part.Text)
+				text.WriteString("\n")
+				part.Text = ""
+			}
+			out[i].Parts[j] = part
+		}
+	}
+	return out
+}
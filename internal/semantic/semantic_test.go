@@ -0,0 +1,100 @@
+package semantic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"ai-wrap/internal/models"
+)
+
+func textRequest(text string) models.GeminiRequest {
+	return models.GeminiRequest{
+		Contents: []models.Content{{Role: "user", Parts: []models.Part{{Text: text}}}},
+	}
+}
+
+func TestFingerprintIgnoresFormatting(t *testing.T) {
+	a := Fingerprint("Summarize the following article about ocean currents.")
+	b := Fingerprint("  summarize the following\n\narticle about   ocean currents. ")
+	if a != b {
+		t.Errorf("expected whitespace and case to be ignored, similarity %.3f", Similarity(a, b))
+	}
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	base := "write a short product description for a stainless steel water bottle that keeps drinks cold for twenty four hours and fits in a car cup holder"
+	near := "write a short product description for a stainless steel water bottle that keeps drinks cold for twenty four hours and fits in any car cup holder"
+	far := "translate the attached contract into german and list every clause that mentions termination or liability"
+
+	nearSim := Similarity(Fingerprint(base), Fingerprint(near))
+	farSim := Similarity(Fingerprint(base), Fingerprint(far))
+	if nearSim <= farSim {
+		t.Errorf("expected near duplicate to be more similar: near %.3f far %.3f", nearSim, farSim)
+	}
+	if farSim >= 0.95 {
+		t.Errorf("expected unrelated prompts below the default threshold, got %.3f", farSim)
+	}
+}
+
+func TestKeyOfBucketsBySettings(t *testing.T) {
+	cold, hot := 0.1, 0.2
+	a := textRequest("hello there")
+	a.GenerationConfig.Temperature = &cold
+	b := textRequest("hello   there ")
+	b.GenerationConfig.Temperature = &cold
+	c := textRequest("hello there")
+	c.GenerationConfig.Temperature = &hot
+
+	ka, kb, kc := KeyOf("m", a), KeyOf("m", b), KeyOf("m", c)
+	if ka != kb {
+		t.Errorf("expected formatting-only change to give the same key")
+	}
+	if ka.Bucket == kc.Bucket {
+		t.Error("expected different temperature to change the bucket")
+	}
+	if ka.Bucket == KeyOf("other", a).Bucket {
+		t.Error("expected model to change the bucket")
+	}
+}
+
+func TestKeyOfCanonicalizesRawJSON(t *testing.T) {
+	a := textRequest("hi")
+	a.Tools = json.RawMessage(`[{"functionDeclarations":[{"name":"f","description":"d"}]}]`)
+	b := textRequest("hi")
+	b.Tools = json.RawMessage(`[ { "functionDeclarations": [ {"description":"d", "name":"f"} ] } ]`)
+
+	if KeyOf("m", a) != KeyOf("m", b) {
+		t.Error("expected key order and whitespace in tools to be ignored")
+	}
+}
+
+func TestIndexLookup(t *testing.T) {
+	x := NewIndex(2)
+	key := KeyOf("m", textRequest("what is the capital of france"))
+
+	x.Add(key, "h1")
+	if match, ok := x.Lookup(key, 0.95); !ok || match.Hash != "h1" || match.Similarity != 1 {
+		t.Errorf("expected exact match, got %+v %t", match, ok)
+	}
+
+	other := key
+	other.Bucket = "different"
+	if _, ok := x.Lookup(other, 0.5); ok {
+		t.Error("expected no match across buckets")
+	}
+
+	x.Add(other, "h2")
+	x.Add(other, "h3")
+	if _, ok := x.Lookup(key, 0.95); ok {
+		t.Error("expected the oldest entry to be evicted")
+	}
+	if x.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", x.Len())
+	}
+
+	x.Remove("h2")
+	x.Remove("h3")
+	if x.Len() != 0 || len(x.buckets) != 0 {
+		t.Errorf("expected empty index, got %d entries %d buckets", x.Len(), len(x.buckets))
+	}
+}
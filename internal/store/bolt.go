@@ -0,0 +1,460 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"ai-wrap/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	requestsBucket    = []byte("requests")
+	cacheIndexBucket  = []byte("cache_index")
+	virtualKeysBucket = []byte("virtual_keys")
+	// tokenIndexBucket maps token hashes to virtual key ids
+	tokenIndexBucket = []byte("virtual_key_tokens")
+	// spendBucket keeps what each virtual key spent per utc day, so budget
+	// checks don't scan the month's logs
+	spendBucket = []byte("virtual_key_spend")
+)
+
+// BoltStore keeps everything in a single bbolt file. request ids are object ids,
+// so the requests bucket is ordered by time and range scans walk back from the
+// newest entry. stats are computed by scanning, which is fine for small volumes
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var _ Store = (*BoltStore)(nil)
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt db: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{requestsBucket, cacheIndexBucket, virtualKeysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// files written before the indexes existed are indexed once
+		if tx.Bucket(tokenIndexBucket) == nil {
+			if err := buildTokenIndex(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(spendBucket) == nil {
+			if err := buildSpendIndex(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt buckets: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) LogRequest(log *RequestLog) error {
+	if log.ID == "" {
+		log.ID = primitive.NewObjectID().Hex()
+	}
+
+	data, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(requestsBucket).Put([]byte(log.ID), data); err != nil {
+			return err
+		}
+		if err := addSpend(tx.Bucket(spendBucket), log); err != nil {
+			return err
+		}
+		if log.cacheable() {
+			return tx.Bucket(cacheIndexBucket).Put([]byte(log.RequestHash), []byte(log.ID))
+		}
+		return nil
+	})
+}
+
+// FindCached compares the newest log of each hash, the cache index only
+// points at that one
+func (s *BoltStore) FindCached(since time.Time, requestHashes ...string) (*RequestLog, error) {
+	var newest *RequestLog
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, hash := range requestHashes {
+			id := tx.Bucket(cacheIndexBucket).Get([]byte(hash))
+			if id == nil {
+				continue
+			}
+			data := tx.Bucket(requestsBucket).Get(id)
+			if data == nil {
+				continue
+			}
+			var log RequestLog
+			if err := json.Unmarshal(data, &log); err != nil {
+				return err
+			}
+			if log.Timestamp.Before(since) {
+				continue
+			}
+			if newest == nil || log.Timestamp.After(newest.Timestamp) {
+				newest = &log
+			}
+		}
+		return nil
+	})
+	return newest, err
+}
+
+func (s *BoltStore) FindPaginated(ctx context.Context, skip, limit int) ([]RequestLog, error) {
+	logs := []RequestLog{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(requestsBucket).Cursor()
+		i := 0
+		for k, v := c.Last(); k != nil && len(logs) < limit; k, v = c.Prev() {
+			if i++; i <= skip {
+				continue
+			}
+			var log RequestLog
+			if err := json.Unmarshal(v, &log); err != nil {
+				return err
+			}
+			// match the mongo projection, bodies are only served by FindByID
+			log.Request = models.GeminiRequest{}
+			log.Response = nil
+			log.RawResponse = nil
+			logs = append(logs, log)
+		}
+		return nil
+	})
+	return logs, err
+}
+
+func (s *BoltStore) FindByID(ctx context.Context, id string) (*RequestLog, error) {
+	var log *RequestLog
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(requestsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		log = &RequestLog{}
+		return json.Unmarshal(data, log)
+	})
+	return log, err
+}
+
+func (s *BoltStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = int64(tx.Bucket(requestsBucket).Stats().KeyN)
+		return nil
+	})
+	return count, err
+}
+
+// scanSince calls fn for every log at or after since, newest first
+func (s *BoltStore) scanSince(since time.Time, fn func(log *RequestLog)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(requestsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var log RequestLog
+			if err := json.Unmarshal(v, &log); err != nil {
+				return err
+			}
+			if log.Timestamp.Before(since) {
+				return nil
+			}
+			fn(&log)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetStats(ctx context.Context, since time.Time) (Stats, error) {
+	var stats Stats
+	var totalDuration int64
+
+	err := s.scanSince(since, func(log *RequestLog) {
+		stats.Total++
+		if log.Success {
+			stats.Successful++
+		} else {
+			stats.Failed++
+		}
+		if log.CacheHit {
+			stats.CacheHits++
+		}
+		stats.TotalCost += log.Cost.Total
+		spent, saved := log.spending()
+		stats.TotalSpent += spent
+		stats.TotalSaved += saved
+		totalDuration += log.DurationMs
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if stats.Total > 0 {
+		stats.AvgDurationMs = float64(totalDuration) / float64(stats.Total)
+	}
+	return stats, nil
+}
+
+func (s *BoltStore) GetVirtualKeyUsage(ctx context.Context, since time.Time) ([]VirtualKeyUsage, error) {
+	byKey := map[string]*VirtualKeyUsage{}
+	err := s.scanSince(since, func(log *RequestLog) {
+		if log.VirtualKeyID == "" {
+			return
+		}
+		u, ok := byKey[log.VirtualKeyID]
+		if !ok {
+			u = &VirtualKeyUsage{VirtualKeyID: log.VirtualKeyID}
+			byKey[log.VirtualKeyID] = u
+		}
+		spent, _ := log.spending()
+		u.Requests++
+		u.Cost += spent
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]VirtualKeyUsage, 0, len(byKey))
+	for _, u := range byKey {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Cost > usage[j].Cost
+	})
+	return usage, nil
+}
+
+func (s *BoltStore) GetTimeSeries(ctx context.Context, since time.Time, interval Interval) ([]TimeSeriesPoint, error) {
+	buckets := map[string]*TimeSeriesPoint{}
+	err := s.scanSince(since, func(log *RequestLog) {
+		bucket := log.Timestamp.UTC().Format(interval.layout())
+		point, ok := buckets[bucket]
+		if !ok {
+			point = &TimeSeriesPoint{Bucket: bucket}
+			buckets[bucket] = point
+		}
+		spent, saved := log.spending()
+		point.Count++
+		point.Spent += spent
+		point.Saved += saved
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]TimeSeriesPoint, 0, len(buckets))
+	for _, point := range buckets {
+		points = append(points, *point)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Bucket < points[j].Bucket
+	})
+	return points, nil
+}
+
+func (s *BoltStore) CreateVirtualKey(ctx context.Context, key *VirtualKey) error {
+	return s.putVirtualKey(key, false)
+}
+
+func (s *BoltStore) UpdateVirtualKey(ctx context.Context, key *VirtualKey) error {
+	return s.putVirtualKey(key, true)
+}
+
+func (s *BoltStore) putVirtualKey(key *VirtualKey, mustExist bool) error {
+	data, err := virtualKeyJSON(key)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(virtualKeysBucket)
+		previous := b.Get([]byte(key.ID))
+		if mustExist && previous == nil {
+			return fmt.Errorf("virtual key %s: %w", key.ID, ErrNotFound)
+		}
+		if !mustExist && previous != nil {
+			return fmt.Errorf("virtual key %s already exists", key.ID)
+		}
+
+		tokens := tx.Bucket(tokenIndexBucket)
+		if owner := tokens.Get([]byte(key.TokenHash)); owner != nil && string(owner) != key.ID {
+			return fmt.Errorf("virtual key %s: token already in use", key.ID)
+		}
+		if previous != nil {
+			old, err := parseVirtualKey(previous)
+			if err != nil {
+				return err
+			}
+			if old.TokenHash != key.TokenHash {
+				if err := tokens.Delete([]byte(old.TokenHash)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tokens.Put([]byte(key.TokenHash), []byte(key.ID)); err != nil {
+			return err
+		}
+		return b.Put([]byte(key.ID), data)
+	})
+}
+
+func (s *BoltStore) FindVirtualKey(ctx context.Context, id string) (*VirtualKey, error) {
+	var key *VirtualKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(virtualKeysBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		var err error
+		key, err = parseVirtualKey(data)
+		return err
+	})
+	return key, err
+}
+
+func (s *BoltStore) FindVirtualKeyByToken(ctx context.Context, token string) (*VirtualKey, error) {
+	var key *VirtualKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(tokenIndexBucket).Get([]byte(HashToken(token)))
+		if id == nil {
+			return nil
+		}
+		data := tx.Bucket(virtualKeysBucket).Get(id)
+		if data == nil {
+			return nil
+		}
+		var err error
+		key, err = parseVirtualKey(data)
+		return err
+	})
+	return key, err
+}
+
+func (s *BoltStore) ListVirtualKeys(ctx context.Context) ([]VirtualKey, error) {
+	keys := []VirtualKey{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(virtualKeysBucket).ForEach(func(k, v []byte) error {
+			key, err := parseVirtualKey(v)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, *key)
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// GetVirtualKeySpend adds up the key's days in the spend index
+func (s *BoltStore) GetVirtualKeySpend(ctx context.Context, id string, now time.Time) (VirtualKeySpend, error) {
+	dayStart, monthStart := periodStarts(now)
+	today := spendKey(id, dayStart)
+
+	var spend VirtualKeySpend
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(spendBucket).Cursor()
+		for k, v := c.Seek(spendKey(id, monthStart)); k != nil && bytes.Compare(k, today) <= 0; k, v = c.Next() {
+			spent := math.Float64frombits(binary.BigEndian.Uint64(v))
+			spend.Monthly += spent
+			if bytes.Equal(k, today) {
+				spend.Daily += spent
+			}
+		}
+		return nil
+	})
+	return spend, err
+}
+
+// spendKey is the spend index key of a virtual key's utc day. keys of one
+// virtual key sort by day
+func spendKey(id string, t time.Time) []byte {
+	return []byte(id + "\x00" + t.UTC().Format("2006-01-02"))
+}
+
+// addSpend adds what log spent to its virtual key's day
+func addSpend(b *bolt.Bucket, log *RequestLog) error {
+	spent, _ := log.spending()
+	if log.VirtualKeyID == "" || spent == 0 {
+		return nil
+	}
+	key := spendKey(log.VirtualKeyID, log.Timestamp)
+	if v := b.Get(key); v != nil {
+		spent += math.Float64frombits(binary.BigEndian.Uint64(v))
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, math.Float64bits(spent))
+	return b.Put(key, value)
+}
+
+func buildSpendIndex(tx *bolt.Tx) error {
+	b, err := tx.CreateBucket(spendBucket)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(requestsBucket).ForEach(func(k, v []byte) error {
+		var log RequestLog
+		if err := json.Unmarshal(v, &log); err != nil {
+			return err
+		}
+		return addSpend(b, &log)
+	})
+}
+
+func buildTokenIndex(tx *bolt.Tx) error {
+	b, err := tx.CreateBucket(tokenIndexBucket)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(virtualKeysBucket).ForEach(func(k, v []byte) error {
+		key, err := parseVirtualKey(v)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key.TokenHash), k)
+	})
+}
+
+// VirtualKey hides the token hash from json, so it is stored via a shadow type
+type storedVirtualKey struct {
+	VirtualKey
+	TokenHash string `json:"token_hash"`
+}
+
+func virtualKeyJSON(key *VirtualKey) ([]byte, error) {
+	return json.Marshal(storedVirtualKey{VirtualKey: *key, TokenHash: key.TokenHash})
+}
+
+func parseVirtualKey(data []byte) (*VirtualKey, error) {
+	var stored storedVirtualKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	stored.VirtualKey.TokenHash = stored.TokenHash
+	return &stored.VirtualKey, nil
+}
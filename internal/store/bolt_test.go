@@ -0,0 +1,334 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestBoltStore(t *testing.T) *BoltStore {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open bolt store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBoltStoreLogAndFindCached(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	resp := &models.GeminiResponse{UsageMetadata: models.UsageMetadata{TotalTokenCount: 3}}
+	logs := []*RequestLog{
+		{Timestamp: time.Now(), Model: "m", RequestHash: "h1", Success: true, Response: resp, Cost: models.Cost{Total: 0.5}, DurationMs: 10},
+		{Timestamp: time.Now(), Model: "m", RequestHash: "h2", Success: false, Cost: models.Cost{Total: 0}, DurationMs: 30, VirtualKeyID: "vk_1"},
+		{Timestamp: time.Now(), Model: "m", RequestHash: "h1", Success: true, Response: resp, CacheHit: true, Cost: models.Cost{Total: 0.25}, DurationMs: 20, VirtualKeyID: "vk_1"},
+	}
+	for _, log := range logs {
+		if err := s.LogRequest(log); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+	}
+
+	cached, err := s.FindCached(time.Time{}, "h1")
+	if err != nil || cached == nil {
+		t.Fatalf("expected cached log for h1, got %v (%v)", cached, err)
+	}
+	if cached.ID != logs[2].ID {
+		t.Errorf("expected newest log %s, got %s", logs[2].ID, cached.ID)
+	}
+
+	if missing, _ := s.FindCached(time.Time{}, "h2"); missing != nil {
+		t.Error("failed request must not be served from cache")
+	}
+
+	page, err := s.FindPaginated(ctx, 1, 10)
+	if err != nil || len(page) != 2 {
+		t.Fatalf("expected 2 logs after skipping 1, got %d (%v)", len(page), err)
+	}
+	if page[0].ID != logs[1].ID || page[0].Response != nil {
+		t.Errorf("expected newest-first page without bodies, got %+v", page[0])
+	}
+
+	stats, err := s.GetStats(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if stats.Total != 3 || stats.Successful != 2 || stats.CacheHits != 1 || stats.TotalCost != 0.75 || stats.AvgDurationMs != 20 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	spend, err := s.GetVirtualKeySpend(ctx, "vk_1", time.Now())
+	if err != nil || spend.Daily != 0 || spend.Monthly != 0 {
+		t.Errorf("unexpected spend: %+v (%v)", spend, err)
+	}
+}
+
+func TestBoltStoreVirtualKeys(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	key, token := NewVirtualKey()
+	key.Name = "team-a"
+	if err := s.CreateVirtualKey(ctx, key); err != nil {
+		t.Fatalf("failed to create virtual key: %v", err)
+	}
+
+	found, err := s.FindVirtualKeyByToken(ctx, token)
+	if err != nil || found == nil || found.ID != key.ID || found.Name != "team-a" {
+		t.Fatalf("expected to find key by token, got %+v (%v)", found, err)
+	}
+
+	if missing, _ := s.FindVirtualKeyByToken(ctx, token+"x"); missing != nil {
+		t.Error("expected no key for wrong token")
+	}
+
+	found.Active = false
+	if err := s.UpdateVirtualKey(ctx, found); err != nil {
+		t.Fatalf("failed to update virtual key: %v", err)
+	}
+	updated, _ := s.FindVirtualKey(ctx, key.ID)
+	if updated == nil || updated.Active || updated.TokenHash != key.TokenHash {
+		t.Errorf("expected inactive key with token hash kept, got %+v", updated)
+	}
+}
+
+func TestBoltStoreExcludeFromCache(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	resp := &models.GeminiResponse{UsageMetadata: models.UsageMetadata{TotalTokenCount: 3}}
+	now := time.Now()
+	logs := []*RequestLog{
+		{Timestamp: now.Add(-2 * time.Hour), Model: "a", RequestHash: "h1", Success: true, Response: resp},
+		{Timestamp: now.Add(-time.Hour), Model: "a", RequestHash: "h1", Success: true, Response: resp},
+		{Timestamp: now, Model: "b", RequestHash: "h2", Success: true, Response: resp},
+	}
+	for _, log := range logs {
+		if err := s.LogRequest(log); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+	}
+
+	// marking the newest h1 log falls back to the older one
+	if err := s.SetCacheable(ctx, logs[1].ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := s.FindCached(time.Time{}, "h1"); cached == nil || cached.ID != logs[0].ID {
+		t.Errorf("expected the older h1 log, got %+v", cached)
+	}
+	if err := s.SetCacheable(ctx, "missing", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	hashes, err := s.ExcludeFromCache(ctx, CacheFilter{Model: "a", Until: now.Add(-30 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 1 || hashes[0] != "h1" {
+		t.Errorf("expected [h1], got %v", hashes)
+	}
+	if cached, _ := s.FindCached(time.Time{}, "h1"); cached != nil {
+		t.Errorf("expected h1 to be excluded, got %+v", cached)
+	}
+	if cached, _ := s.FindCached(time.Time{}, "h2"); cached == nil {
+		t.Error("expected h2 to be untouched")
+	}
+
+	if err := s.SetCacheable(ctx, logs[1].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := s.FindCached(time.Time{}, "h1"); cached == nil || cached.ID != logs[1].ID {
+		t.Errorf("expected the re-enabled h1 log, got %+v", cached)
+	}
+}
+
+func TestBoltStoreFindCachedNewestWithinAge(t *testing.T) {
+	s := newTestBoltStore(t)
+
+	resp := &models.GeminiResponse{UsageMetadata: models.UsageMetadata{TotalTokenCount: 3}}
+	now := time.Now()
+	legacy := &RequestLog{Timestamp: now.Add(-time.Hour), RequestHash: "legacy", Success: true, Response: resp}
+	current := &RequestLog{Timestamp: now.Add(-2 * time.Hour), RequestHash: "v2:h", Success: true, Response: resp}
+	for _, log := range []*RequestLog{current, legacy} {
+		if err := s.LogRequest(log); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+	}
+
+	if cached, _ := s.FindCached(time.Time{}, "v2:h", "legacy"); cached == nil || cached.ID != legacy.ID {
+		t.Errorf("expected the newest log across hashes, got %+v", cached)
+	}
+	if cached, _ := s.FindCached(now.Add(-90*time.Minute), "v2:h"); cached != nil {
+		t.Errorf("expected a log older than since to be skipped, got %+v", cached)
+	}
+}
+
+func TestBoltStoreSpentAndSaved(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	logs := []*RequestLog{
+		{Timestamp: now, Success: true, Cost: models.Cost{Total: 0.5}, Spent: 0.5},
+		{Timestamp: now, Success: true, CacheHit: true, Cost: models.Cost{Total: 0.5}, Saved: 0.5},
+		// written before spent and saved were recorded
+		{Timestamp: now, Success: true, CacheHit: true, Cost: models.Cost{Total: 0.25}},
+		{Timestamp: now, Success: true, Cost: models.Cost{Total: 0.125}},
+	}
+	for _, log := range logs {
+		if err := s.LogRequest(log); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+	}
+
+	stats, err := s.GetStats(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalSpent != 0.625 || stats.TotalSaved != 0.75 || stats.TotalCost != 1.375 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	points, err := s.GetTimeSeries(ctx, now.Add(-time.Hour), IntervalHour)
+	if err != nil || len(points) != 1 {
+		t.Fatalf("expected one bucket, got %+v (%v)", points, err)
+	}
+	if points[0].Count != 4 || points[0].Spent != 0.625 || points[0].Saved != 0.75 {
+		t.Errorf("unexpected point: %+v", points[0])
+	}
+}
+
+func TestBoltStoreExcludeFromCacheLegacyLogs(t *testing.T) {
+	s := newTestBoltStore(t)
+	ctx := context.Background()
+
+	req := models.GeminiRequest{Contents: []models.Content{{Parts: []models.Part{{Text: "hi"}}}}}
+	resp := &models.GeminiResponse{UsageMetadata: models.UsageMetadata{TotalTokenCount: 3}}
+	legacyHash := LegacyHashRequest(req)
+	logs := []*RequestLog{
+		{Timestamp: time.Now(), Model: "a", Request: req, RequestHash: legacyHash, Success: true, Response: resp},
+		{Timestamp: time.Now(), Model: "b", Request: req, RequestHash: legacyHash, Success: true, Response: resp},
+		{Timestamp: time.Now(), Model: "a", Request: req, RequestHash: HashRequest("a", req), Success: true, Response: resp},
+	}
+	for _, log := range logs {
+		if err := s.LogRequest(log); err != nil {
+			t.Fatalf("failed to log request: %v", err)
+		}
+	}
+
+	hashes, err := s.ExcludeFromCache(ctx, CacheFilter{RequestHash: HashRequest("a", req)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 {
+		t.Errorf("expected the current and the legacy hash, got %v", hashes)
+	}
+
+	excluded := map[string]bool{}
+	for _, log := range logs {
+		stored, err := s.FindByID(ctx, log.ID)
+		if err != nil || stored == nil {
+			t.Fatalf("failed to find log %s: %v", log.ID, err)
+		}
+		excluded[stored.Model+" "+stored.RequestHash] = stored.NoCache
+	}
+	if !excluded["a "+legacyHash] || !excluded["a "+HashRequest("a", req)] {
+		t.Errorf("expected model a's logs excluded under both hashes, got %v", excluded)
+	}
+	if excluded["b "+legacyHash] {
+		t.Error("expected the legacy log of another model to stay cacheable")
+	}
+	if cached, _ := s.FindCached(time.Time{}, legacyHash); cached == nil || cached.Model != "b" {
+		t.Errorf("expected only model b's legacy log left, got %+v", cached)
+	}
+}
+
+func TestBoltStoreVirtualKeySpend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	now := time.Date(2025, 3, 15, 12, 0, 0, 0, time.UTC)
+	for _, log := range []*RequestLog{
+		{Timestamp: now, Success: true, VirtualKeyID: "vk_1", Spent: 0.5},
+		{Timestamp: now.Add(-time.Hour), Success: true, VirtualKeyID: "vk_1", Cost: models.Cost{Total: 0.25}}, // legacy
+		{Timestamp: now.AddDate(0, 0, -3), Success: true, VirtualKeyID: "vk_1", Spent: 1},
+		{Timestamp: now.AddDate(0, -1, 0), Success: true, VirtualKeyID: "vk_1", Spent: 8},
+		{Timestamp: now, Success: true, VirtualKeyID: "vk_1", CacheHit: true, Saved: 2},
+		{Timestamp: now, Success: true, VirtualKeyID: "vk_10", Spent: 4},
+	} {
+		if err := s.LogRequest(log); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := VirtualKeySpend{Daily: 0.75, Monthly: 1.75}
+	if spend, err := s.GetVirtualKeySpend(ctx, "vk_1", now); err != nil || spend != want {
+		t.Errorf("expected %+v, got %+v (%v)", want, spend, err)
+	}
+
+	// a file written before the spend index existed is indexed on open
+	s.db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket(spendBucket) })
+	s.Close()
+	s = newReopenedBoltStore(t, path)
+	if spend, err := s.GetVirtualKeySpend(ctx, "vk_1", now); err != nil || spend != want {
+		t.Errorf("expected %+v after reindexing, got %+v (%v)", want, spend, err)
+	}
+}
+
+func TestBoltStoreTokenIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	key, token := NewVirtualKey()
+	if err := s.CreateVirtualKey(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewVirtualKey()
+	other.TokenHash = key.TokenHash
+	if err := s.CreateVirtualKey(ctx, other); err == nil {
+		t.Error("expected a second key with the same token to be rejected")
+	}
+
+	// a rotated token no longer finds the key
+	rotated := *key
+	newToken := token + "-rotated"
+	rotated.TokenHash = HashToken(newToken)
+	if err := s.UpdateVirtualKey(ctx, &rotated); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := s.FindVirtualKeyByToken(ctx, token); found != nil {
+		t.Error("expected the old token to be forgotten")
+	}
+
+	s.db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket(tokenIndexBucket) })
+	s.Close()
+	s = newReopenedBoltStore(t, path)
+	if found, err := s.FindVirtualKeyByToken(ctx, newToken); err != nil || found == nil || found.ID != key.ID {
+		t.Errorf("expected the key after reindexing, got %+v (%v)", found, err)
+	}
+}
+
+func newReopenedBoltStore(t *testing.T, path string) *BoltStore {
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen bolt store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
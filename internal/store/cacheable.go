@@ -0,0 +1,317 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CacheFilter selects logs for ExcludeFromCache. empty fields match any log,
+// Until is exclusive
+type CacheFilter struct {
+	RequestHash string
+	Model       string
+	Since       time.Time
+	Until       time.Time
+}
+
+// IsEmpty reports whether the filter matches every log
+func (f CacheFilter) IsEmpty() bool {
+	return f.RequestHash == "" && f.Model == "" && f.Since.IsZero() && f.Until.IsZero()
+}
+
+func (f CacheFilter) matches(log *RequestLog) bool {
+	if f.RequestHash != "" && log.RequestHash != f.RequestHash {
+		return false
+	}
+	if f.Model != "" && log.Model != f.Model {
+		return false
+	}
+	if !f.Since.IsZero() && log.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !log.Timestamp.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// legacyKey identifies v1 logs by hash and requested model, the store
+// fallback serves them to requests sent to that model
+func legacyKey(hash string, log *RequestLog) string {
+	return hash + "\x00" + log.HashedModel(nil)
+}
+
+// spreadsToLegacy reports whether a purge by filter must also exclude the v1
+// logs of the requests it matches. other filters match v1 logs directly
+func (f CacheFilter) spreadsToLegacy() bool {
+	return isCurrentHash(f.RequestHash)
+}
+
+// reachesSource reports whether a purge by filter excludes a semantic hit
+// that served the response of the purged request
+func (f CacheFilter) reachesSource(log *RequestLog) bool {
+	if f.RequestHash == "" || log.SourceHash != f.RequestHash {
+		return false
+	}
+	rest := f
+	rest.RequestHash = ""
+	return rest.matches(log)
+}
+
+// cacheable reports whether FindCached may serve the log. semantic hits
+// answered another request, they never are
+func (log *RequestLog) cacheable() bool {
+	return log.Success && log.Response != nil && log.RequestHash != "" && !log.NoCache && log.SourceHash == ""
+}
+
+func (s *MongoStore) SetCacheable(ctx context.Context, id string, cacheable bool) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("request %s: %w", id, ErrNotFound)
+	}
+
+	update := bson.M{"$set": bson.M{"no_cache": true}}
+	if cacheable {
+		update = bson.M{"$unset": bson.M{"no_cache": ""}}
+	}
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": oid}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("request %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *MongoStore) ExcludeFromCache(ctx context.Context, filter CacheFilter) ([]string, error) {
+	query := bson.M{"success": true}
+	if filter.RequestHash != "" {
+		query["request_hash"] = filter.RequestHash
+	}
+	if filter.Model != "" {
+		query["model"] = filter.Model
+	}
+	timestamp := bson.M{}
+	if !filter.Since.IsZero() {
+		timestamp["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		timestamp["$lt"] = filter.Until
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+
+	or := []bson.M{query}
+	if filter.spreadsToLegacy() {
+		legacy, err := s.legacyQuery(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		if legacy != nil {
+			or = append(or, legacy)
+		}
+	}
+	if filter.RequestHash != "" {
+		// semantic hits that served the purged response
+		source := bson.M{"source_hash": filter.RequestHash}
+		for k, v := range query {
+			if k != "request_hash" {
+				source[k] = v
+			}
+		}
+		or = append(or, source)
+	}
+	if len(or) > 1 {
+		query = bson.M{"$or": or}
+	}
+
+	values, err := s.collection.Distinct(ctx, "request_hash", query)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.collection.UpdateMany(ctx, query, bson.M{"$set": bson.M{"no_cache": true}}); err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(values))
+	for _, v := range values {
+		if hash, ok := v.(string); ok && hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// legacyQuery matches the v1 logs of the requests query matches, nil if none
+func (s *MongoStore) legacyQuery(ctx context.Context, query bson.M) (bson.M, error) {
+	opts := options.Find().SetProjection(bson.M{"request": 1, "model": 1, "requested_model": 1})
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	seen := map[string]bool{}
+	var or []bson.M
+	for cursor.Next(ctx) {
+		var log RequestLog
+		if err := cursor.Decode(&log); err != nil {
+			return nil, err
+		}
+		if key := legacyKey(LegacyHashRequest(log.Request), &log); !seen[key] {
+			seen[key] = true
+			model := log.HashedModel(nil)
+			or = append(or, bson.M{
+				"request_hash": LegacyHashRequest(log.Request),
+				"$or": []bson.M{
+					{"requested_model": model},
+					{"requested_model": bson.M{"$in": []interface{}{nil, ""}}, "model": model},
+				},
+			})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	if len(or) == 0 {
+		return nil, nil
+	}
+	return bson.M{"success": true, "$or": or}, nil
+}
+
+// SetCacheable also points the cache index at the newest cacheable log left
+// for the request hash
+func (s *BoltStore) SetCacheable(ctx context.Context, id string, cacheable bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		requests := tx.Bucket(requestsBucket)
+		data := requests.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("request %s: %w", id, ErrNotFound)
+		}
+		var log RequestLog
+		if err := json.Unmarshal(data, &log); err != nil {
+			return err
+		}
+
+		log.NoCache = !cacheable
+		data, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		if err := requests.Put([]byte(log.ID), data); err != nil {
+			return err
+		}
+		if log.RequestHash == "" {
+			return nil
+		}
+		return reindexCache(tx, map[string]bool{log.RequestHash: true})
+	})
+}
+
+func (s *BoltStore) ExcludeFromCache(ctx context.Context, filter CacheFilter) ([]string, error) {
+	var hashes []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		requests := tx.Bucket(requestsBucket)
+
+		// collect first, bbolt doesn't allow writes while a cursor walks the bucket
+		var matched, legacy, served []RequestLog
+		err := requests.ForEach(func(k, v []byte) error {
+			var log RequestLog
+			if err := json.Unmarshal(v, &log); err != nil {
+				return err
+			}
+			switch {
+			case !log.Success:
+				// never served
+			case filter.matches(&log):
+				matched = append(matched, log)
+			case filter.reachesSource(&log):
+				served = append(served, log)
+			case filter.spreadsToLegacy() && log.RequestHash != "" && !isCurrentHash(log.RequestHash):
+				legacy = append(legacy, log)
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+
+		// v1 logs of the matched requests would keep serving them
+		keys := map[string]bool{}
+		for i := range matched {
+			keys[legacyKey(LegacyHashRequest(matched[i].Request), &matched[i])] = true
+		}
+		for i := range legacy {
+			if keys[legacyKey(legacy[i].RequestHash, &legacy[i])] {
+				matched = append(matched, legacy[i])
+			}
+		}
+		matched = append(matched, served...)
+
+		affected := map[string]bool{}
+		for _, log := range matched {
+			if !log.NoCache {
+				log.NoCache = true
+				data, err := json.Marshal(log)
+				if err != nil {
+					return err
+				}
+				if err := requests.Put([]byte(log.ID), data); err != nil {
+					return err
+				}
+			}
+			if log.RequestHash != "" && !affected[log.RequestHash] {
+				affected[log.RequestHash] = true
+				hashes = append(hashes, log.RequestHash)
+			}
+		}
+		return reindexCache(tx, affected)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// reindexCache points the cache index entry of each hash at its newest
+// cacheable log, or drops it when there is none
+func reindexCache(tx *bolt.Tx, hashes map[string]bool) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	newest := map[string][]byte{}
+	c := tx.Bucket(requestsBucket).Cursor()
+	for k, v := c.Last(); k != nil && len(newest) < len(hashes); k, v = c.Prev() {
+		var log RequestLog
+		if err := json.Unmarshal(v, &log); err != nil {
+			return err
+		}
+		if hashes[log.RequestHash] && newest[log.RequestHash] == nil && log.cacheable() {
+			newest[log.RequestHash] = []byte(log.ID)
+		}
+	}
+
+	index := tx.Bucket(cacheIndexBucket)
+	for hash := range hashes {
+		var err error
+		if id := newest[hash]; id != nil {
+			err = index.Put([]byte(hash), id)
+		} else {
+			err = index.Delete([]byte(hash))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
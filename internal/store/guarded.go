@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ai-wrap/internal/breaker"
+)
+
+var (
+	// ErrUnavailable is returned while the backend is down or its breaker is open
+	ErrUnavailable = errors.New("store unavailable")
+	// ErrNotFound is a missing record, which says nothing about store health
+	ErrNotFound = errors.New("not found")
+)
+
+// GuardedStore lets the proxy start and keep serving without its store. calls
+// fail fast with ErrUnavailable until the store connects or recovers
+type GuardedStore struct {
+	dep *breaker.Dependency[Store]
+}
+
+var _ Store = (*GuardedStore)(nil)
+var _ breaker.Reporter = (*GuardedStore)(nil)
+
+func NewGuardedStore(backend string, connect func() (Store, error), retry time.Duration) *GuardedStore {
+	return &GuardedStore{dep: breaker.NewDependency(backend, connect, retry)}
+}
+
+func (s *GuardedStore) Health() breaker.Health {
+	return s.dep.Health()
+}
+
+func (s *GuardedStore) Close() error {
+	if conn, ok := s.dep.Close(); ok {
+		return conn.Close()
+	}
+	return nil
+}
+
+// guard makes call on the connection. ctx is the caller's, a call it cancels
+// doesn't count against the breaker
+func guard[R any](ctx context.Context, s *GuardedStore, call func(Store) (R, error)) (R, error) {
+	conn, ok := s.dep.Acquire()
+	if !ok {
+		var zero R
+		return zero, ErrUnavailable
+	}
+	result, err := call(conn)
+	if errors.Is(err, ErrNotFound) {
+		s.dep.Done(ctx, nil)
+	} else {
+		s.dep.Done(ctx, err)
+	}
+	return result, err
+}
+
+func guardErr(ctx context.Context, s *GuardedStore, call func(Store) error) error {
+	_, err := guard(ctx, s, func(conn Store) (struct{}, error) {
+		return struct{}{}, call(conn)
+	})
+	return err
+}
+
+// LogRequest and FindCached time out on their own, a deadline there is the
+// store being slow
+func (s *GuardedStore) LogRequest(log *RequestLog) error {
+	return guardErr(context.Background(), s, func(conn Store) error { return conn.LogRequest(log) })
+}
+
+func (s *GuardedStore) FindCached(since time.Time, requestHashes ...string) (*RequestLog, error) {
+	return guard(context.Background(), s, func(conn Store) (*RequestLog, error) { return conn.FindCached(since, requestHashes...) })
+}
+
+func (s *GuardedStore) SetCacheable(ctx context.Context, id string, cacheable bool) error {
+	return guardErr(ctx, s, func(conn Store) error { return conn.SetCacheable(ctx, id, cacheable) })
+}
+
+func (s *GuardedStore) ExcludeFromCache(ctx context.Context, filter CacheFilter) ([]string, error) {
+	return guard(ctx, s, func(conn Store) ([]string, error) { return conn.ExcludeFromCache(ctx, filter) })
+}
+
+func (s *GuardedStore) FindPaginated(ctx context.Context, skip, limit int) ([]RequestLog, error) {
+	return guard(ctx, s, func(conn Store) ([]RequestLog, error) { return conn.FindPaginated(ctx, skip, limit) })
+}
+
+func (s *GuardedStore) FindByID(ctx context.Context, id string) (*RequestLog, error) {
+	return guard(ctx, s, func(conn Store) (*RequestLog, error) { return conn.FindByID(ctx, id) })
+}
+
+func (s *GuardedStore) Count(ctx context.Context) (int64, error) {
+	return guard(ctx, s, func(conn Store) (int64, error) { return conn.Count(ctx) })
+}
+
+func (s *GuardedStore) GetStats(ctx context.Context, since time.Time) (Stats, error) {
+	return guard(ctx, s, func(conn Store) (Stats, error) { return conn.GetStats(ctx, since) })
+}
+
+func (s *GuardedStore) GetVirtualKeyUsage(ctx context.Context, since time.Time) ([]VirtualKeyUsage, error) {
+	return guard(ctx, s, func(conn Store) ([]VirtualKeyUsage, error) { return conn.GetVirtualKeyUsage(ctx, since) })
+}
+
+func (s *GuardedStore) GetTimeSeries(ctx context.Context, since time.Time, interval Interval) ([]TimeSeriesPoint, error) {
+	return guard(ctx, s, func(conn Store) ([]TimeSeriesPoint, error) { return conn.GetTimeSeries(ctx, since, interval) })
+}
+
+func (s *GuardedStore) CreateVirtualKey(ctx context.Context, key *VirtualKey) error {
+	return guardErr(ctx, s, func(conn Store) error { return conn.CreateVirtualKey(ctx, key) })
+}
+
+func (s *GuardedStore) FindVirtualKeyByToken(ctx context.Context, token string) (*VirtualKey, error) {
+	return guard(ctx, s, func(conn Store) (*VirtualKey, error) { return conn.FindVirtualKeyByToken(ctx, token) })
+}
+
+func (s *GuardedStore) FindVirtualKey(ctx context.Context, id string) (*VirtualKey, error) {
+	return guard(ctx, s, func(conn Store) (*VirtualKey, error) { return conn.FindVirtualKey(ctx, id) })
+}
+
+func (s *GuardedStore) ListVirtualKeys(ctx context.Context) ([]VirtualKey, error) {
+	return guard(ctx, s, func(conn Store) ([]VirtualKey, error) { return conn.ListVirtualKeys(ctx) })
+}
+
+func (s *GuardedStore) UpdateVirtualKey(ctx context.Context, key *VirtualKey) error {
+	return guardErr(ctx, s, func(conn Store) error { return conn.UpdateVirtualKey(ctx, key) })
+}
+
+func (s *GuardedStore) GetVirtualKeySpend(ctx context.Context, id string, now time.Time) (VirtualKeySpend, error) {
+	return guard(ctx, s, func(conn Store) (VirtualKeySpend, error) { return conn.GetVirtualKeySpend(ctx, id, now) })
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"ai-wrap/internal/models"
+)
+
+// HashVersion prefixes request hashes, so the scheme can change without
+// colliding with hashes already stored in logs and caches. v1 hashes are
+// unprefixed (see LegacyHashRequest)
+const HashVersion = "v2"
+
+// HashRequest hashes the canonical form of req sent to model, so requests
+// gemini treats the same share cache entries. model is the configured model
+// the request resolved to (after aliases, before fallbacks)
+func HashRequest(model string, req models.GeminiRequest) string {
+	hash := sha256.Sum256(CanonicalJSON(hashedRequest{Model: model, Request: Canonicalize(req)}))
+	return HashVersion + ":" + hex.EncodeToString(hash[:])
+}
+
+// ModelResolver maps a requested model name to the configured model it routes
+// to, e.g. config.ResolveModel. nil leaves names as they are
+type ModelResolver func(name string) string
+
+// HashedModel is the model the log's request was hashed for: the one the
+// client asked for, resolved. logs from before requested_model was recorded
+// fall back to the model that answered
+func (log *RequestLog) HashedModel(resolve ModelResolver) string {
+	name := log.RequestedModel
+	if name == "" {
+		name = log.Model
+	}
+	if resolve == nil {
+		return name
+	}
+	return resolve(name)
+}
+
+type hashedRequest struct {
+	Model   string               `json:"model"`
+	Request models.GeminiRequest `json:"request"`
+}
+
+// LegacyHashRequest is the v1 scheme, a hash of the plain json encoding. it
+// doesn't cover the model, so a v1 log may answer for another model
+func LegacyHashRequest(req models.GeminiRequest) string {
+	data, _ := json.Marshal(req)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// RequestHashes lists every hash req to model may be logged under, current first
+func RequestHashes(model string, req models.GeminiRequest) []string {
+	return []string{HashRequest(model, req), LegacyHashRequest(req)}
+}
+
+// Canonicalize fills in what gemini assumes when a field is left out, so an
+// explicit default and an absent field hash the same. the result is only
+// hashed, never sent upstream
+func Canonicalize(req models.GeminiRequest) models.GeminiRequest {
+	out := req
+
+	// a content without a role is a user turn
+	out.Contents = make([]models.Content, len(req.Contents))
+	for i, content := range req.Contents {
+		if content.Role == "" {
+			content.Role = "user"
+		}
+		out.Contents[i] = content
+	}
+
+	// the system instruction role is ignored upstream
+	if req.SystemInstruction != nil {
+		system := *req.SystemInstruction
+		system.Role = ""
+		out.SystemInstruction = &system
+	}
+
+	config := req.GenerationConfig
+	if config.Temperature == nil {
+		// the proxy also prices and caches an absent temperature as 1.0
+		temp := 1.0
+		config.Temperature = &temp
+	}
+	if config.CandidateCount != nil && *config.CandidateCount == 1 {
+		config.CandidateCount = nil
+	}
+	if config.ResponseMimeType == "text/plain" {
+		config.ResponseMimeType = ""
+	}
+	if tc := config.ThinkingConfig; tc != nil && tc.IncludeThoughts == nil && tc.ThinkingBudget == nil && tc.ThinkingLevel == "" {
+		config.ThinkingConfig = nil
+	}
+	out.GenerationConfig = config
+
+	return out
+}
+
+// CanonicalJSON encodes v with sorted object keys, no insignificant whitespace
+// and numbers in one form (1.0, 1e0 and 1 all become 1), including inside raw
+// json fields like tools and schemas. integers are kept digit for digit, so
+// ids beyond float64 precision don't collide
+func CanonicalJSON(v any) []byte {
+	data, _ := json.Marshal(v)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return data
+	}
+	canonical, _ := json.Marshal(canonicalNumbers(generic))
+	return canonical
+}
+
+func canonicalNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = canonicalNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = canonicalNumbers(item)
+		}
+	case json.Number:
+		return canonicalNumber(v)
+	}
+	return v
+}
+
+// canonicalNumber leaves integer literals as they are and formats everything
+// else the way encoding/json formats a float64
+func canonicalNumber(n json.Number) json.Number {
+	if !strings.ContainsAny(string(n), ".eE") {
+		if n == "-0" {
+			return "0"
+		}
+		return n
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return n
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return n
+	}
+	return json.Number(data)
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-wrap/internal/models"
+)
+
+func hashOf(t *testing.T, body string) string {
+	var req models.GeminiRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	return HashRequest("gemini-2.5-flash", req)
+}
+
+func TestHashRequestIgnoresIrrelevantDifferences(t *testing.T) {
+	cases := map[string][2]string{
+		"default temperature": {
+			`{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`,
+			`{"contents":[{"role":"user","parts":[{"text":"hi"}]}],"generationConfig":{"temperature":1.0}}`,
+		},
+		"default role": {
+			`{"contents":[{"parts":[{"text":"hi"}]}]}`,
+			`{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`,
+		},
+		"system role": {
+			`{"contents":[{"parts":[{"text":"hi"}]}],"systemInstruction":{"parts":[{"text":"be brief"}]}}`,
+			`{"contents":[{"parts":[{"text":"hi"}]}],"systemInstruction":{"role":"system","parts":[{"text":"be brief"}]}}`,
+		},
+		"numeric representation": {
+			`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"temperature":0.1,"topP":1}}`,
+			`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"temperature":0.10,"topP":1.0}}`,
+		},
+		"exponent form": {
+			`{"contents":[{"parts":[{"text":"hi"}]}],"tools":[{"x":100,"y":-0}]}`,
+			`{"contents":[{"parts":[{"text":"hi"}]}],"tools":[{"x":1e2,"y":0}]}`,
+		},
+		"key order in raw json": {
+			`{"contents":[{"parts":[{"text":"hi"}]}],"tools":[{"functionDeclarations":[{"name":"f","parameters":{"type":"object","required":["a"]}}]}]}`,
+			`{"tools":[ {"functionDeclarations":[ {"parameters":{"required":["a"],"type":"object"},"name":"f"} ]} ],"contents":[{"parts":[{"text":"hi"}]}]}`,
+		},
+		"default candidate count": {
+			`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"candidateCount":1}}`,
+			`{"contents":[{"parts":[{"text":"hi"}]}]}`,
+		},
+	}
+
+	for name, pair := range cases {
+		if a, b := hashOf(t, pair[0]), hashOf(t, pair[1]); a != b {
+			t.Errorf("%s: expected equal hashes, got %s and %s", name, a, b)
+		}
+	}
+}
+
+func TestHashRequestKeepsRelevantDifferences(t *testing.T) {
+	cases := map[string][2]string{
+		"text": {
+			`{"contents":[{"parts":[{"text":"hi"}]}]}`,
+			`{"contents":[{"parts":[{"text":"hello"}]}]}`,
+		},
+		"temperature": {
+			`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"temperature":0.1}}`,
+			`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"temperature":0.2}}`,
+		},
+		"role": {
+			`{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`,
+			`{"contents":[{"role":"model","parts":[{"text":"hi"}]}]}`,
+		},
+		"large integers": {
+			`{"contents":[{"parts":[{"functionCall":{"name":"f","args":{"id":9007199254740993}}}]}]}`,
+			`{"contents":[{"parts":[{"functionCall":{"name":"f","args":{"id":9007199254740992}}}]}]}`,
+		},
+		"array order": {
+			`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"stopSequences":["a","b"]}}`,
+			`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"stopSequences":["b","a"]}}`,
+		},
+	}
+
+	for name, pair := range cases {
+		if a, b := hashOf(t, pair[0]), hashOf(t, pair[1]); a == b {
+			t.Errorf("%s: expected different hashes", name)
+		}
+	}
+}
+
+func TestHashRequestCoversModel(t *testing.T) {
+	req := models.GeminiRequest{Contents: []models.Content{{Parts: []models.Part{{Text: "hi"}}}}}
+	if HashRequest("gemini-2.5-flash", req) == HashRequest("gemini-2.5-pro", req) {
+		t.Error("expected different hashes for different models")
+	}
+}
+
+func TestRequestHashesVersions(t *testing.T) {
+	req := models.GeminiRequest{Contents: []models.Content{{Parts: []models.Part{{Text: "hi"}}}}}
+	hashes := RequestHashes("m", req)
+
+	if !strings.HasPrefix(hashes[0], HashVersion+":") {
+		t.Errorf("expected current hash first, got %s", hashes[0])
+	}
+	if hashes[1] != LegacyHashRequest(req) || strings.Contains(hashes[1], ":") {
+		t.Errorf("expected unprefixed legacy hash, got %s", hashes[1])
+	}
+}
+
+func TestBoltStoreMigrateHashes(t *testing.T) {
+	s := newTestBoltStore(t)
+	req := models.GeminiRequest{Contents: []models.Content{{Parts: []models.Part{{Text: "hi"}}}}}
+	resp := &models.GeminiResponse{ModelVersion: "v"}
+
+	// answered by a fallback model, hashed for what "fast" resolves to
+	log := &RequestLog{Model: "lite", RequestedModel: "fast", Request: req, RequestHash: LegacyHashRequest(req), Success: true, Response: resp}
+	if err := s.LogRequest(log); err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(name string) string {
+		if name == "fast" {
+			return "flash"
+		}
+		return name
+	}
+
+	// matched under the legacy hash before migrating
+	if cached, _ := s.FindCached(time.Time{}, RequestHashes("flash", req)...); cached == nil {
+		t.Fatal("expected legacy hash to match")
+	}
+
+	migrated, err := s.MigrateHashes(t.Context(), resolve)
+	if err != nil || migrated != 1 {
+		t.Fatalf("expected 1 migrated log, got %d (%v)", migrated, err)
+	}
+	if cached, _ := s.FindCached(time.Time{}, HashRequest("flash", req)); cached == nil || cached.ID != log.ID {
+		t.Errorf("expected the log under the current hash, got %+v", cached)
+	}
+	if cached, _ := s.FindCached(time.Time{}, LegacyHashRequest(req)); cached != nil {
+		t.Error("expected the legacy index entry to be gone")
+	}
+
+	if again, _ := s.MigrateHashes(t.Context(), resolve); again != 0 {
+		t.Errorf("expected rerun to be a no-op, migrated %d", again)
+	}
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultKeyCacheTTL is how long a virtual key found by token is reused
+const DefaultKeyCacheTTL = 30 * time.Second
+
+// KeyCachedStore remembers virtual keys found by token, so authenticating a
+// request doesn't cost a mongodb round trip. updates made through it drop the
+// key at once, other instances see them within ttl. unknown tokens are not
+// remembered, a new key works right away
+type KeyCachedStore struct {
+	*GuardedStore
+	ttl time.Duration
+
+	mu      sync.Mutex
+	byToken map[string]cachedKey // token hash → key
+}
+
+type cachedKey struct {
+	key       VirtualKey
+	expiresAt time.Time
+}
+
+var _ Store = (*KeyCachedStore)(nil)
+
+func NewKeyCachedStore(inner *GuardedStore, ttl time.Duration) *KeyCachedStore {
+	return &KeyCachedStore{GuardedStore: inner, ttl: ttl, byToken: make(map[string]cachedKey)}
+}
+
+func (s *KeyCachedStore) FindVirtualKeyByToken(ctx context.Context, token string) (*VirtualKey, error) {
+	hash := HashToken(token)
+	s.mu.Lock()
+	cached, ok := s.byToken[hash]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		key := cached.key
+		return &key, nil
+	}
+
+	key, err := s.GuardedStore.FindVirtualKeyByToken(ctx, token)
+	if err != nil || key == nil {
+		return key, err
+	}
+	s.mu.Lock()
+	s.byToken[hash] = cachedKey{key: *key, expiresAt: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+	return key, nil
+}
+
+func (s *KeyCachedStore) UpdateVirtualKey(ctx context.Context, key *VirtualKey) error {
+	// forget it even if the update fails, it may have gone through
+	defer s.forget(key.ID)
+	return s.GuardedStore.UpdateVirtualKey(ctx, key)
+}
+
+func (s *KeyCachedStore) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, cached := range s.byToken {
+		if cached.key.ID == id || time.Now().After(cached.expiresAt) {
+			delete(s.byToken, hash)
+		}
+	}
+}
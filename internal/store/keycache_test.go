@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyCachedStoreForgetsUpdatedKeys(t *testing.T) {
+	inner := newTestBoltStore(t)
+	ctx := context.Background()
+	s := NewKeyCachedStore(NewGuardedStore("bolt", func() (Store, error) { return inner, nil }, time.Second), time.Minute)
+
+	key, token := NewVirtualKey()
+	if err := s.CreateVirtualKey(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := s.FindVirtualKeyByToken(ctx, token); err != nil || found == nil || !found.Active {
+		t.Fatalf("expected the active key, got %+v (%v)", found, err)
+	}
+
+	// changed behind its back, the cached key is served until the ttl
+	revoked := *key
+	revoked.Active = false
+	if err := inner.UpdateVirtualKey(ctx, &revoked); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := s.FindVirtualKeyByToken(ctx, token); found == nil || !found.Active {
+		t.Errorf("expected the cached key, got %+v", found)
+	}
+
+	if err := s.UpdateVirtualKey(ctx, &revoked); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := s.FindVirtualKeyByToken(ctx, token); found == nil || found.Active {
+		t.Errorf("expected the revoked key right after the update, got %+v", found)
+	}
+}
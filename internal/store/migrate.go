@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HashMigrator rewrites the request_hash of logs written under an older hash
+// version. lookups match older versions anyway, migrating just saves the
+// extra candidates. resolve maps each log's requested model to the model the
+// current hash covers
+type HashMigrator interface {
+	MigrateHashes(ctx context.Context, resolve ModelResolver) (int, error)
+}
+
+var _ HashMigrator = (*MongoStore)(nil)
+var _ HashMigrator = (*BoltStore)(nil)
+
+const migrateBatchSize = 500
+
+func isCurrentHash(hash string) bool {
+	return strings.HasPrefix(hash, HashVersion+":")
+}
+
+func (s *MongoStore) MigrateHashes(ctx context.Context, resolve ModelResolver) (int, error) {
+	filter := bson.M{"request_hash": bson.M{"$not": primitive.Regex{Pattern: "^" + HashVersion + ":"}}}
+	opts := options.Find().SetProjection(bson.M{"request": 1, "request_hash": 1, "model": 1, "requested_model": 1})
+
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := s.collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+		if result != nil {
+			migrated += int(result.ModifiedCount)
+		}
+		batch = batch[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var log RequestLog
+		if err := cursor.Decode(&log); err != nil {
+			return migrated, err
+		}
+		oid, err := primitive.ObjectIDFromHex(log.ID)
+		if err != nil {
+			continue
+		}
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": oid}).
+			SetUpdate(bson.M{"$set": bson.M{"request_hash": HashRequest(log.HashedModel(resolve), log.Request)}}))
+		if len(batch) >= migrateBatchSize {
+			if err := flush(); err != nil {
+				return migrated, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return migrated, err
+	}
+	return migrated, flush()
+}
+
+// MigrateHashes also moves the cache index entries to the new hashes
+func (s *BoltStore) MigrateHashes(ctx context.Context, resolve ModelResolver) (int, error) {
+	migrated := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		requests := tx.Bucket(requestsBucket)
+		index := tx.Bucket(cacheIndexBucket)
+
+		// collect first, bbolt doesn't allow writes while a cursor walks the bucket
+		var stale []RequestLog
+		err := requests.ForEach(func(k, v []byte) error {
+			var log RequestLog
+			if err := json.Unmarshal(v, &log); err != nil {
+				return err
+			}
+			if log.RequestHash != "" && !isCurrentHash(log.RequestHash) {
+				stale = append(stale, log)
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, log := range stale {
+			oldHash := log.RequestHash
+			log.RequestHash = HashRequest(log.HashedModel(resolve), log.Request)
+			data, err := json.Marshal(log)
+			if err != nil {
+				return err
+			}
+			if err := requests.Put([]byte(log.ID), data); err != nil {
+				return err
+			}
+			if string(index.Get([]byte(oldHash))) == log.ID {
+				if err := index.Delete([]byte(oldHash)); err != nil {
+					return err
+				}
+				if err := index.Put([]byte(log.RequestHash), []byte(log.ID)); err != nil {
+					return err
+				}
+			}
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return migrated, nil
+}
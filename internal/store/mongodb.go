@@ -2,14 +2,10 @@ package store
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	"ai-wrap/internal/config"
-	"ai-wrap/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,10 +14,13 @@ import (
 )
 
 type MongoStore struct {
-	client     *mongo.Client
-	collection *mongo.Collection
+	client      *mongo.Client
+	collection  *mongo.Collection
+	virtualKeys *mongo.Collection
 }
 
+var _ Store = (*MongoStore)(nil)
+
 func NewMongoStore(cfg *config.Config) (*MongoStore, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -35,11 +34,12 @@ func NewMongoStore(cfg *config.Config) (*MongoStore, error) {
 		return nil, fmt.Errorf("failed to ping mongodb: %w", err)
 	}
 
-	collection := client.Database(cfg.MongoDB.Database).Collection(cfg.MongoDB.Collection)
+	db := client.Database(cfg.MongoDB.Database)
 
 	return &MongoStore{
-		client:     client,
-		collection: collection,
+		client:      client,
+		collection:  db.Collection(cfg.MongoDB.Collection),
+		virtualKeys: db.Collection(cfg.MongoDB.VirtualKeysCollection),
 	}, nil
 }
 
@@ -57,17 +57,23 @@ func (s *MongoStore) LogRequest(log *RequestLog) error {
 	return err
 }
 
-func (s *MongoStore) FindCached(requestHash string) (*RequestLog, error) {
+func (s *MongoStore) FindCached(since time.Time, requestHashes ...string) (*RequestLog, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
 	filter := bson.M{
-		"request_hash": requestHash,
+		"request_hash": bson.M{"$in": requestHashes},
 		"success":      true,
+		"no_cache":     bson.M{"$ne": true},
+		"source_hash":  bson.M{"$exists": false},
+	}
+	if !since.IsZero() {
+		filter["timestamp"] = bson.M{"$gte": since}
 	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}})
 
 	var log RequestLog
-	err := s.collection.FindOne(ctx, filter).Decode(&log)
+	err := s.collection.FindOne(ctx, filter, opts).Decode(&log)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -78,8 +84,116 @@ func (s *MongoStore) FindCached(requestHash string) (*RequestLog, error) {
 	return &log, nil
 }
 
-func (s *MongoStore) GetCollection() *mongo.Collection {
-	return s.collection
+// Count uses the estimated count (fast, uses collection metadata)
+func (s *MongoStore) Count(ctx context.Context) (int64, error) {
+	return s.collection.EstimatedDocumentCount(ctx)
+}
+
+func (s *MongoStore) GetStats(ctx context.Context, since time.Time) (Stats, error) {
+	// single aggregation for all stats
+	pipeline := []bson.M{
+		{"$match": bson.M{"timestamp": bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id":               nil,
+			"total":             bson.M{"$sum": 1},
+			"successful":        bson.M{"$sum": bson.M{"$cond": []interface{}{"$success", 1, 0}}},
+			"failed":            bson.M{"$sum": bson.M{"$cond": []interface{}{"$success", 0, 1}}},
+			"cache_hits":        bson.M{"$sum": bson.M{"$cond": []interface{}{"$cache_hit", 1, 0}}},
+			"total_cost":        bson.M{"$sum": "$cost.total"},
+			"total_spent":       bson.M{"$sum": spentExpr},
+			"total_saved":       bson.M{"$sum": savedExpr},
+			"avg_response_time": bson.M{"$avg": "$duration_ms"},
+		}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Total           int64   `bson:"total"`
+		Successful      int64   `bson:"successful"`
+		Failed          int64   `bson:"failed"`
+		CacheHits       int64   `bson:"cache_hits"`
+		TotalCost       float64 `bson:"total_cost"`
+		TotalSpent      float64 `bson:"total_spent"`
+		TotalSaved      float64 `bson:"total_saved"`
+		AvgResponseTime float64 `bson:"avg_response_time"`
+	}
+
+	if cursor.Next(ctx) {
+		cursor.Decode(&result)
+	}
+
+	return Stats{
+		Total:         result.Total,
+		Successful:    result.Successful,
+		Failed:        result.Failed,
+		CacheHits:     result.CacheHits,
+		TotalCost:     result.TotalCost,
+		TotalSpent:    result.TotalSpent,
+		TotalSaved:    result.TotalSaved,
+		AvgDurationMs: result.AvgResponseTime,
+	}, nil
+}
+
+func (s *MongoStore) GetVirtualKeyUsage(ctx context.Context, since time.Time) ([]VirtualKeyUsage, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"timestamp":      bson.M{"$gte": since},
+			"virtual_key_id": bson.M{"$exists": true, "$ne": ""},
+		}},
+		{"$group": bson.M{
+			"_id":      "$virtual_key_id",
+			"requests": bson.M{"$sum": 1},
+			"cost":     bson.M{"$sum": spentExpr},
+		}},
+		{"$sort": bson.M{"cost": -1}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	usage := []VirtualKeyUsage{}
+	if err := cursor.All(ctx, &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (s *MongoStore) GetTimeSeries(ctx context.Context, since time.Time, interval Interval) ([]TimeSeriesPoint, error) {
+	groupBy := "%Y-%m-%d %H:00"
+	if interval == IntervalDay {
+		groupBy = "%Y-%m-%d"
+	}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"timestamp": bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": groupBy, "date": "$timestamp"}},
+			"count": bson.M{"$sum": 1},
+			"spent": bson.M{"$sum": spentExpr},
+			"saved": bson.M{"$sum": savedExpr},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	points := []TimeSeriesPoint{}
+	if err := cursor.All(ctx, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
 }
 
 func (s *MongoStore) FindPaginated(ctx context.Context, skip, limit int) ([]RequestLog, error) {
@@ -88,8 +202,9 @@ func (s *MongoStore) FindPaginated(ctx context.Context, skip, limit int) ([]Requ
 		SetSkip(int64(skip)).
 		SetLimit(int64(limit)).
 		SetProjection(bson.M{
-			"request":  0,
-			"response": 0,
+			"request":      0,
+			"response":     0,
+			"raw_response": 0,
 		})
 
 	cursor, err := s.collection.Find(ctx, bson.M{}, opts)
@@ -109,18 +224,15 @@ func (s *MongoStore) FindPaginated(ctx context.Context, skip, limit int) ([]Requ
 func (s *MongoStore) FindByID(ctx context.Context, id string) (*RequestLog, error) {
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, err
+		return nil, nil
 	}
 	var log RequestLog
 	err = s.collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&log)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &log, nil
 }
-
-func HashRequest(req models.GeminiRequest) string {
-	data, _ := json.Marshal(req)
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
-}
@@ -1,28 +1,70 @@
 package store
 
 import (
+	"encoding/json"
 	"time"
 
 	"ai-wrap/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
+// RequestLog is one proxied request. Cost prices the response at list rates,
+// Spent is what the call actually cost upstream and Saved what a cache hit
+// avoided. one of them is Cost.Total, the other 0
 type RequestLog struct {
-	ID            string                 `bson:"_id,omitempty"`
-	Timestamp     time.Time              `bson:"timestamp"`
-	Model         string                 `bson:"model"`
-	Request       models.GeminiRequest   `bson:"request"`
-	Response      *models.GeminiResponse `bson:"response,omitempty"`
-	StatusCode    int                    `bson:"status_code"`
-	Success       bool                   `bson:"success"`
-	Error         string                 `bson:"error,omitempty"`
-	Cost          models.Cost            `bson:"cost"`
-	Temperature   float64                `bson:"temperature"`
-	KeySource     string                 `bson:"key_source"`
-	CacheHit      bool                   `bson:"cache_hit"`
-	RequestHash   string                 `bson:"request_hash"`
-	DurationMs    int64                  `bson:"duration_ms"`
-	PromptTokens  int                    `bson:"prompt_tokens"`
-	OutputTokens  int                    `bson:"output_tokens"`
-	TotalTokens   int                    `bson:"total_tokens"`
-	IsVision      bool                   `bson:"is_vision"`
+	ID             string                 `bson:"_id,omitempty"`
+	Timestamp      time.Time              `bson:"timestamp"`
+	Model          string                 `bson:"model"`
+	RequestedModel string                 `bson:"requested_model,omitempty"`
+	Request        models.GeminiRequest   `bson:"request"`
+	Response       *models.GeminiResponse `bson:"response,omitempty"`
+	RawResponse    json.RawMessage        `bson:"raw_response,omitempty"`
+	StatusCode     int                    `bson:"status_code"`
+	Success        bool                   `bson:"success"`
+	Error          string                 `bson:"error,omitempty"`
+	Cost           models.Cost            `bson:"cost"`
+	Spent          float64                `bson:"spent"`
+	Saved          float64                `bson:"saved"`
+	Temperature    float64                `bson:"temperature"`
+	KeySource      string                 `bson:"key_source"`
+	CacheHit       bool                   `bson:"cache_hit"`
+	CacheSource    string                 `bson:"cache_source,omitempty"`
+	Similarity     float64                `bson:"similarity,omitempty"`
+	RequestHash    string                 `bson:"request_hash"`
+	// SourceHash is the request hash whose response a semantic hit served
+	SourceHash   string `bson:"source_hash,omitempty"`
+	NoCache      bool   `bson:"no_cache,omitempty"`
+	DurationMs   int64  `bson:"duration_ms"`
+	PromptTokens int    `bson:"prompt_tokens"`
+	OutputTokens int    `bson:"output_tokens"`
+	TotalTokens  int    `bson:"total_tokens"`
+	IsVision     bool   `bson:"is_vision"`
+	VirtualKeyID string `bson:"virtual_key_id,omitempty"`
+}
+
+// CachedResponse is the logged response with its upstream body restored
+func (log *RequestLog) CachedResponse() *models.GeminiResponse {
+	if log.Response != nil && len(log.RawResponse) > 0 {
+		log.Response.Raw = log.RawResponse
+	}
+	return log.Response
 }
+
+// spending is Spent and Saved, derived from the cache hit flag for logs
+// written before they were recorded
+func (log *RequestLog) spending() (spent, saved float64) {
+	if log.Spent != 0 || log.Saved != 0 {
+		return log.Spent, log.Saved
+	}
+	if log.CacheHit {
+		return 0, log.Cost.Total
+	}
+	return log.Cost.Total, 0
+}
+
+// spentExpr and savedExpr are spending() as mongodb aggregation expressions
+var (
+	spentExpr = bson.M{"$ifNull": []interface{}{"$spent", bson.M{"$cond": []interface{}{"$cache_hit", 0, "$cost.total"}}}}
+	savedExpr = bson.M{"$ifNull": []interface{}{"$saved", bson.M{"$cond": []interface{}{"$cache_hit", "$cost.total", 0}}}}
+)
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Store persists request logs and virtual keys. MongoStore is the default,
+// BoltStore is an embedded single-file alternative for small deployments
+type Store interface {
+	LogRequest(log *RequestLog) error
+	// FindCached returns the newest successful log logged under any of the
+	// request hashes (see RequestHashes) at or after since, nil if none. a
+	// zero since means any age, logs marked NoCache and semantic hits are
+	// skipped
+	FindCached(since time.Time, requestHashes ...string) (*RequestLog, error)
+	// SetCacheable marks a log as usable by FindCached or not, ErrNotFound if
+	// there is no such log
+	SetCacheable(ctx context.Context, id string, cacheable bool) error
+	// ExcludeFromCache marks every successful log matching filter NoCache and
+	// returns their distinct request hashes, including logs already marked so
+	// a retry still finds what to drop from the cache. a current RequestHash
+	// also excludes the v1 logs of the same request and requested model, which
+	// the fallback would serve otherwise, and the semantic hits that served
+	// the request's response
+	ExcludeFromCache(ctx context.Context, filter CacheFilter) ([]string, error)
+	// FindPaginated returns logs newest first, without request/response bodies
+	FindPaginated(ctx context.Context, skip, limit int) ([]RequestLog, error)
+	// FindByID returns nil if there is no such log
+	FindByID(ctx context.Context, id string) (*RequestLog, error)
+	Count(ctx context.Context) (int64, error)
+	GetStats(ctx context.Context, since time.Time) (Stats, error)
+	GetVirtualKeyUsage(ctx context.Context, since time.Time) ([]VirtualKeyUsage, error)
+	GetTimeSeries(ctx context.Context, since time.Time, interval Interval) ([]TimeSeriesPoint, error)
+
+	CreateVirtualKey(ctx context.Context, key *VirtualKey) error
+	FindVirtualKeyByToken(ctx context.Context, token string) (*VirtualKey, error)
+	FindVirtualKey(ctx context.Context, id string) (*VirtualKey, error)
+	ListVirtualKeys(ctx context.Context) ([]VirtualKey, error)
+	UpdateVirtualKey(ctx context.Context, key *VirtualKey) error
+	GetVirtualKeySpend(ctx context.Context, id string, now time.Time) (VirtualKeySpend, error)
+
+	Close() error
+}
+
+// Stats sums the logs of a period. TotalCost is spent plus saved, see RequestLog
+type Stats struct {
+	Total         int64
+	Successful    int64
+	Failed        int64
+	CacheHits     int64
+	TotalCost     float64
+	TotalSpent    float64
+	TotalSaved    float64
+	AvgDurationMs float64
+}
+
+type VirtualKeyUsage struct {
+	VirtualKeyID string  `bson:"_id"`
+	Requests     int64   `bson:"requests"`
+	Cost         float64 `bson:"cost"`
+}
+
+// Interval is the bucket size of a time series
+type Interval string
+
+const (
+	IntervalHour Interval = "hour"
+	IntervalDay  Interval = "day"
+)
+
+// layout formats a utc timestamp into its bucket label
+func (i Interval) layout() string {
+	if i == IntervalDay {
+		return "2006-01-02"
+	}
+	return "2006-01-02 15:00"
+}
+
+type TimeSeriesPoint struct {
+	Bucket string  `bson:"_id"`
+	Count  int     `bson:"count"`
+	Spent  float64 `bson:"spent"`
+	Saved  float64 `bson:"saved"`
+}
+
+// periodStarts returns the start of the utc day and month containing now
+func periodStarts(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return dayStart, monthStart
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// VirtualKeyPrefix marks proxy-issued keys so they can't be confused with gemini keys
+const VirtualKeyPrefix = "aiw-"
+
+// VirtualKey is a proxy-issued credential for a team/project. only the sha256
+// of the token is stored, the token itself is shown once on creation
+type VirtualKey struct {
+	ID            string    `bson:"_id" json:"id"`
+	TokenHash     string    `bson:"token_hash" json:"-"`
+	TokenPrefix   string    `bson:"token_prefix" json:"token_prefix"`
+	Name          string    `bson:"name" json:"name"`
+	Team          string    `bson:"team" json:"team"`
+	Project       string    `bson:"project" json:"project"`
+	DailyBudget   float64   `bson:"daily_budget" json:"daily_budget"`
+	MonthlyBudget float64   `bson:"monthly_budget" json:"monthly_budget"`
+	Active        bool      `bson:"active" json:"active"`
+	CreatedAt     time.Time `bson:"created_at" json:"created_at"`
+}
+
+type VirtualKeySpend struct {
+	Daily   float64 `bson:"daily" json:"daily"`
+	Monthly float64 `bson:"monthly" json:"monthly"`
+}
+
+// NewVirtualKey generates an active key with a random id and token. the
+// returned token is the only copy of the secret
+func NewVirtualKey() (*VirtualKey, string) {
+	id := make([]byte, 6)
+	rand.Read(id)
+	secret := make([]byte, 24)
+	rand.Read(secret)
+
+	token := VirtualKeyPrefix + hex.EncodeToString(secret)
+
+	return &VirtualKey{
+		ID:          "vk_" + hex.EncodeToString(id),
+		TokenHash:   HashToken(token),
+		TokenPrefix: token[:len(VirtualKeyPrefix)+6] + "...",
+		Active:      true,
+		CreatedAt:   time.Now(),
+	}, token
+}
+
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *MongoStore) CreateVirtualKey(ctx context.Context, key *VirtualKey) error {
+	_, err := s.virtualKeys.InsertOne(ctx, key)
+	return err
+}
+
+func (s *MongoStore) FindVirtualKeyByToken(ctx context.Context, token string) (*VirtualKey, error) {
+	var key VirtualKey
+	err := s.virtualKeys.FindOne(ctx, bson.M{"token_hash": HashToken(token)}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *MongoStore) FindVirtualKey(ctx context.Context, id string) (*VirtualKey, error) {
+	var key VirtualKey
+	err := s.virtualKeys.FindOne(ctx, bson.M{"_id": id}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *MongoStore) ListVirtualKeys(ctx context.Context) ([]VirtualKey, error) {
+	cursor, err := s.virtualKeys.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []VirtualKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *MongoStore) UpdateVirtualKey(ctx context.Context, key *VirtualKey) error {
+	result, err := s.virtualKeys.ReplaceOne(ctx, bson.M{"_id": key.ID}, key)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("virtual key %s: %w", key.ID, ErrNotFound)
+	}
+	return nil
+}
+
+// GetVirtualKeySpend sums what a key actually spent since the start of the
+// current utc day and month. cache hits cost nothing
+func (s *MongoStore) GetVirtualKeySpend(ctx context.Context, id string, now time.Time) (VirtualKeySpend, error) {
+	dayStart, monthStart := periodStarts(now)
+
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"virtual_key_id": id,
+			"timestamp":      bson.M{"$gte": monthStart},
+		}},
+		{"$group": bson.M{
+			"_id":     nil,
+			"monthly": bson.M{"$sum": spentExpr},
+			"daily": bson.M{"$sum": bson.M{"$cond": []interface{}{
+				bson.M{"$gte": []interface{}{"$timestamp", dayStart}}, spentExpr, 0,
+			}}},
+		}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return VirtualKeySpend{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var spend VirtualKeySpend
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&spend); err != nil {
+			return VirtualKeySpend{}, err
+		}
+	}
+	return spend, nil
+}
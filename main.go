@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
+	"ai-wrap/internal/breaker"
 	"ai-wrap/internal/cache"
 	"ai-wrap/internal/client"
 	"ai-wrap/internal/config"
 	"ai-wrap/internal/handler"
 	"ai-wrap/internal/keymanager"
+	"ai-wrap/internal/reload"
+	"ai-wrap/internal/semantic"
 	"ai-wrap/internal/store"
 
 	"github.com/gin-contrib/cors"
@@ -20,13 +25,14 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
+	cfgHolder := config.NewHolder("config.yaml", cfg)
 
 	log.Printf("loaded %d models from config:", len(cfg.Costs.Models))
 	for _, model := range cfg.Costs.Models {
 		log.Printf("  - %s", model.Name)
 	}
 
-	km, err := keymanager.New("data/keys.csv")
+	km, err := keymanager.New(cfg.Keys.Path)
 	if err != nil {
 		log.Printf("warning: failed to load keys from csv: %v", err)
 		log.Printf("will accept user-provided api keys only")
@@ -35,23 +41,47 @@ func main() {
 		log.Printf("loaded %d active keys from csv", km.ActiveCount())
 	}
 
-	redisCache, err := cache.NewRedisCache(cfg)
-	if err != nil {
-		log.Fatalf("failed to connect to redis: %v", err)
+	checker := keymanager.NewChecker(cfgHolder)
+	if cfg.Keys.CheckInterval > 0 {
+		km.StartHealthCheck(context.Background(), checker, time.Duration(cfg.Keys.CheckInterval)*time.Second, cfg.Keys.CheckReactivate)
+		log.Printf("key health check every %ds", cfg.Keys.CheckInterval)
 	}
-	defer redisCache.Close()
-	log.Printf("connected to redis")
 
-	mongoStore, err := store.NewMongoStore(cfg)
+	responseCache, err := cache.New(cfg)
 	if err != nil {
-		log.Fatalf("failed to connect to mongodb: %v", err)
+		log.Fatalf("failed to set up %s cache: %v", cfg.Cache.Backend, err)
+	}
+	defer responseCache.Close()
+	log.Printf("using %s cache", responseCache.Name())
+
+	var logStore store.Store
+	switch cfg.Storage.Backend {
+	case "bolt":
+		logStore, err = store.NewBoltStore(cfg.Storage.Path)
+		if err != nil {
+			log.Fatalf("failed to open bolt store: %v", err)
+		}
+		log.Printf("opened bolt store at %s", cfg.Storage.Path)
+	default:
+		// mongodb is optional at runtime, the proxy serves without logs until it connects
+		guarded := store.NewGuardedStore("mongodb", func() (store.Store, error) {
+			return store.NewMongoStore(cfg)
+		}, breaker.DefaultRetryInterval)
+		// bolt finds keys by token locally, mongodb lookups are worth caching
+		logStore = store.NewKeyCachedStore(guarded, store.DefaultKeyCacheTTL)
 	}
-	defer mongoStore.Close()
-	log.Printf("connected to mongodb")
+	defer logStore.Close()
 
-	geminiClient := client.NewGeminiClient(cfg, km)
-	proxyHandler := handler.NewProxyHandler(cfg, redisCache, mongoStore, geminiClient, km)
-	adminHandler := handler.NewAdminHandler(mongoStore)
+	reload.Watch(context.Background(), reload.DefaultInterval,
+		reload.File{Path: "config.yaml", Reload: cfgHolder.Reload},
+		reload.File{Path: cfg.Keys.Path, Reload: km.Reload},
+	)
+
+	geminiClient := client.NewGeminiClient(cfgHolder, km)
+	// shared so cache purges also drop near-duplicate matches
+	semanticIndex := semantic.NewIndex(cfg.Cache.Semantic.MaxEntries)
+	proxyHandler := handler.NewProxyHandler(cfgHolder, responseCache, logStore, geminiClient, km, semanticIndex)
+	adminHandler := handler.NewAdminHandler(logStore, responseCache, semanticIndex, km, checker)
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
@@ -61,13 +91,31 @@ func main() {
 
 	r.GET("/health", proxyHandler.Health)
 	r.POST("/v1beta/models/*path", proxyHandler.Handle)
+	r.POST("/v1/chat/completions", proxyHandler.ChatCompletions)
 
-	admin := r.Group("/admin")
+	if cfg.Auth.AdminToken == "" {
+		log.Printf("admin api disabled, set auth.admin_token (ADMIN_TOKEN) to enable it")
+	}
+	admin := r.Group("/admin", handler.AdminAuth(cfgHolder))
 	{
 		admin.GET("/stats", adminHandler.GetStats)
 		admin.GET("/requests", adminHandler.GetRequests)
 		admin.GET("/requests/:id", adminHandler.GetRequest)
+		admin.PUT("/requests/:id/cacheable", adminHandler.SetRequestCacheable)
 		admin.GET("/timeseries", adminHandler.GetTimeSeries)
+		admin.GET("/keys", adminHandler.ListKeys)
+		admin.POST("/keys", adminHandler.AddKey)
+		admin.POST("/keys/:id/deactivate", adminHandler.DeactivateKey)
+		admin.POST("/keys/:id/reactivate", adminHandler.ReactivateKey)
+		admin.POST("/keys/:id/test", adminHandler.TestKey)
+		admin.GET("/virtual-keys", adminHandler.ListVirtualKeys)
+		admin.POST("/virtual-keys", adminHandler.CreateVirtualKey)
+		admin.PUT("/virtual-keys/:id", adminHandler.UpdateVirtualKey)
+		admin.DELETE("/virtual-keys/:id", adminHandler.DeleteVirtualKey)
+		admin.GET("/cache/stats", adminHandler.GetCacheStats)
+		admin.GET("/cache/entries/:hash", adminHandler.GetCacheEntry)
+		admin.DELETE("/cache/entries/:hash", adminHandler.DeleteCacheEntry)
+		admin.POST("/cache/purge", adminHandler.PurgeCache)
 	}
 
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
@@ -3,8 +3,12 @@ package tests
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,6 +19,14 @@ import (
 
 const baseURL = "http://localhost:8089"
 
+// adminToken matches the docker-compose default unless ADMIN_TOKEN is set
+func adminToken() string {
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		return token
+	}
+	return "dev-admin-token"
+}
+
 type apiClient struct {
 	client  *http.Client
 	baseURL string
@@ -42,13 +54,54 @@ func (c *apiClient) health() (map[string]interface{}, error) {
 	return result, nil
 }
 
+// admin calls the admin api with the admin token
+func (c *apiClient) admin(method, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+"/admin"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken())
+	return c.client.Do(req)
+}
+
 func (c *apiClient) generateContent(model string, req models.GeminiRequest) (*http.Response, []byte, error) {
+	return c.generateContentWithHeaders(model, req, nil)
+}
+
+func (c *apiClient) generateContentWithHeaders(model string, req models.GeminiRequest, headers map[string]string) (*http.Response, []byte, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	url := c.baseURL + "/v1beta/models/" + model + ":generateContent"
+	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(httpResp.Body)
+
+	return httpResp, bodyBytes, nil
+}
+
+func (c *apiClient) streamGenerateContent(model string, req models.GeminiRequest) (*http.Response, []byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	url := c.baseURL + "/v1beta/models/" + model + ":streamGenerateContent?alt=sse"
 	httpResp, err := c.client.Post(url, "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		return nil, nil, err
@@ -112,6 +165,33 @@ func TestInvalidModel(t *testing.T) {
 	t.Log("✓ invalid model correctly rejected")
 }
 
+func TestModelAlias(t *testing.T) {
+	client := newAPIClient()
+
+	req := models.GeminiRequest{
+		Contents: []models.Content{
+			{Parts: []models.Part{{Text: "what is 2+2? answer in one word"}}},
+		},
+	}
+
+	httpResp, _, err := client.generateContent("fast", req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", httpResp.StatusCode)
+	}
+
+	// fast -> gemini-2.5-flash, which may fall back to gemini-2.0-flash
+	modelUsed := httpResp.Header.Get("X-Model-Used")
+	if modelUsed != "gemini-2.5-flash" && modelUsed != "gemini-2.0-flash" {
+		t.Errorf("expected alias to be served by its chain, got '%s'", modelUsed)
+	}
+
+	t.Logf("✓ alias served by %s", modelUsed)
+}
+
 func TestValidRequest(t *testing.T) {
 	client := newAPIClient()
 
@@ -208,6 +288,244 @@ func TestCaching(t *testing.T) {
 	} else {
 		t.Log("✓ cache hit on second identical request")
 	}
+	if used1, used2 := httpResp1.Header.Get("X-Model-Used"), httpResp2.Header.Get("X-Model-Used"); used1 != used2 {
+		t.Errorf("expected the hit to report the model that answered (%s), got %s", used1, used2)
+	}
+
+	// the same prompt to another model is a separate entry
+	httpResp3, _, err := client.generateContent("gemini-2.5-flash", req)
+	if err != nil {
+		t.Fatalf("third request failed: %v", err)
+	}
+	if httpResp3.Header.Get("X-Request-Hash") == httpResp1.Header.Get("X-Request-Hash") {
+		t.Error("expected another model not to share the request hash")
+	}
+}
+
+func TestStatsSpentAndSaved(t *testing.T) {
+	client := newAPIClient()
+
+	resp, err := client.admin(http.MethodGet, "/stats")
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var stats map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	spent, ok1 := stats["total_spent"].(float64)
+	saved, ok2 := stats["total_saved"].(float64)
+	total, ok3 := stats["total_cost"].(float64)
+	if !ok1 || !ok2 || !ok3 {
+		t.Fatalf("expected total_spent, total_saved and total_cost in %v", stats)
+	}
+	if diff := spent + saved - total; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("expected spent + saved = total, got %g + %g != %g", spent, saved, total)
+	}
+
+	t.Log("✓ stats split spent and saved")
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	client := newAPIClient()
+
+	resp, err := client.client.Get(client.baseURL + "/admin/stats")
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the admin token, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.admin(http.MethodGet, "/stats")
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with the admin token, got %d", resp.StatusCode)
+	}
+
+	t.Log("✓ admin api requires the admin token")
+}
+
+func TestConcurrentRequestsCoalesce(t *testing.T) {
+	client := newAPIClient()
+
+	temp := 0.1
+	// unique prompt so nothing is cached yet
+	prompt := fmt.Sprintf("what is %d+1? answer with the number only", time.Now().UnixNano()%1000000)
+	req := models.GeminiRequest{
+		Contents: []models.Content{
+			{Parts: []models.Part{{Text: prompt}}},
+		},
+		GenerationConfig: models.GenerationConfig{
+			Temperature: &temp,
+		},
+	}
+
+	const n = 4
+	var wg sync.WaitGroup
+	statuses := make([]string, n)
+	sources := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			httpResp, _, err := client.generateContent("gemini-2.0-flash", req)
+			if err != nil {
+				t.Errorf("request %d failed: %v", i, err)
+				return
+			}
+			if httpResp.StatusCode != http.StatusOK {
+				t.Errorf("request %d: expected status 200, got %d", i, httpResp.StatusCode)
+			}
+			statuses[i] = httpResp.Header.Get("X-Cache-Status")
+			sources[i] = httpResp.Header.Get("X-Cache-Source")
+		}(i)
+	}
+	wg.Wait()
+
+	misses := 0
+	for _, status := range statuses {
+		if status == "MISS" {
+			misses++
+		}
+	}
+	if misses != 1 {
+		t.Errorf("expected exactly one upstream call, got %d misses (%v)", misses, statuses)
+	}
+
+	t.Logf("✓ %d concurrent requests, cache sources %v", n, sources)
+}
+
+func TestCacheControlHeaders(t *testing.T) {
+	client := newAPIClient()
+
+	temp := 0.1
+	req := models.GeminiRequest{
+		Contents: []models.Content{
+			{Parts: []models.Part{{Text: "what is 6+6? answer in one word"}}},
+		},
+		GenerationConfig: models.GenerationConfig{
+			Temperature: &temp,
+		},
+	}
+
+	// warm the cache
+	httpResp, _, err := client.generateContent("gemini-2.0-flash", req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	hash := httpResp.Header.Get("X-Request-Hash")
+	if hash == "" {
+		t.Error("expected request hash header")
+	}
+
+	httpResp, _, err = client.generateContentWithHeaders("gemini-2.0-flash", req, map[string]string{"X-Cache-Bypass": "true"})
+	if err != nil {
+		t.Fatalf("bypass request failed: %v", err)
+	}
+	if status := httpResp.Header.Get("X-Cache-Status"); status != "BYPASS" {
+		t.Errorf("expected BYPASS, got %s", status)
+	}
+
+	httpResp, _, err = client.generateContentWithHeaders("gemini-2.0-flash", req, map[string]string{"X-Cache-Refresh": "true"})
+	if err != nil {
+		t.Fatalf("refresh request failed: %v", err)
+	}
+	if status := httpResp.Header.Get("X-Cache-Status"); status != "REFRESH" {
+		t.Errorf("expected REFRESH, got %s", status)
+	}
+
+	httpResp, _, err = client.generateContent("gemini-2.0-flash", req)
+	if err != nil {
+		t.Fatalf("cached request failed: %v", err)
+	}
+	if status := httpResp.Header.Get("X-Cache-Status"); status != "HIT" {
+		t.Errorf("expected HIT after refresh, got %s", status)
+	}
+	if httpResp.Header.Get("X-Cache-Source") == "" || httpResp.Header.Get("X-Cache-Age") == "" {
+		t.Error("expected cache source and age headers on a hit")
+	}
+	if got := httpResp.Header.Get("X-Request-Hash"); got != hash {
+		t.Errorf("expected the same request hash, got %s and %s", hash, got)
+	}
+
+	httpResp, _, err = client.generateContentWithHeaders("gemini-2.0-flash", req, map[string]string{"X-Cache-TTL": "soon"})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if httpResp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid ttl, got %d", httpResp.StatusCode)
+	}
+
+	t.Log("✓ cache control headers honored")
+}
+
+func TestCacheAdmin(t *testing.T) {
+	client := newAPIClient()
+
+	temp := 0.1
+	req := models.GeminiRequest{
+		Contents: []models.Content{
+			{Parts: []models.Part{{Text: "what is 7+7? answer in one word"}}},
+		},
+		GenerationConfig: models.GenerationConfig{
+			Temperature: &temp,
+		},
+	}
+
+	httpResp, _, err := client.generateContent("gemini-2.0-flash", req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	hash := httpResp.Header.Get("X-Request-Hash")
+	entryPath := "/cache/entries/" + hash
+
+	resp, err := client.admin(http.MethodGet, entryPath)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the entry to exist, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.admin(http.MethodDelete, entryPath)
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected delete to succeed, got %d", resp.StatusCode)
+	}
+
+	httpResp, _, err = client.generateContent("gemini-2.0-flash", req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if status := httpResp.Header.Get("X-Cache-Status"); status != "MISS" {
+		t.Errorf("expected MISS after delete, got %s", status)
+	}
+
+	resp, err = client.admin(http.MethodGet, "/cache/stats")
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var stats map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if _, ok := stats["hit_ratio"]; !ok {
+		t.Errorf("expected hit_ratio in %v", stats)
+	}
+
+	t.Log("✓ cache entry deleted through the admin api")
 }
 
 func TestHighTemperatureNoCache(t *testing.T) {
@@ -236,6 +554,35 @@ func TestHighTemperatureNoCache(t *testing.T) {
 	t.Log("✓ high temperature request not cached")
 }
 
+func TestCountTokens(t *testing.T) {
+	client := newAPIClient()
+
+	body, _ := json.Marshal(models.CountTokensRequest{
+		Contents: []models.Content{
+			{Parts: []models.Part{{Text: "how many tokens is this sentence?"}}},
+		},
+	})
+
+	httpResp, err := client.client.Post(client.baseURL+"/v1beta/models/gemini-2.0-flash:countTokens", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", httpResp.StatusCode)
+	}
+
+	var resp models.CountTokensResponse
+	json.NewDecoder(httpResp.Body).Decode(&resp)
+
+	if resp.TotalTokens == 0 {
+		t.Error("expected non-zero token count")
+	}
+
+	t.Logf("✓ count tokens succeeded: %d tokens", resp.TotalTokens)
+}
+
 func TestCostBlocking(t *testing.T) {
 	client := newAPIClient()
 
@@ -284,6 +631,151 @@ func TestCostBlocking(t *testing.T) {
 	t.Logf("  predicted: $%.6f, max: $%.6f", predictedCost, maxCost)
 }
 
+func TestStreamRequest(t *testing.T) {
+	client := newAPIClient()
+
+	temp := 0.1
+	req := models.GeminiRequest{
+		Contents: []models.Content{
+			{Parts: []models.Part{{Text: "count from 1 to 5, separated by commas"}}},
+		},
+		GenerationConfig: models.GenerationConfig{
+			Temperature: &temp,
+		},
+	}
+
+	httpResp, bodyBytes, err := client.streamGenerateContent("gemini-2.0-flash", req)
+	if err != nil {
+		t.Fatalf("stream request failed: %v", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. response: %s", httpResp.StatusCode, string(bodyBytes))
+	}
+
+	if ct := httpResp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("expected text/event-stream content type, got %s", ct)
+	}
+
+	var events int
+	for _, line := range strings.Split(string(bodyBytes), "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		var chunk models.GeminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+			t.Errorf("failed to parse sse event: %v", err)
+		}
+		events++
+	}
+
+	if events == 0 {
+		t.Fatal("expected at least one sse event")
+	}
+
+	t.Log("✓ stream request succeeded")
+	t.Logf("  events: %d, cache: %s", events, httpResp.Header.Get("X-Cache-Status"))
+}
+
+func TestChatCompletions(t *testing.T) {
+	client := newAPIClient()
+
+	body := []byte(`{
+		"model": "gemini-2.0-flash",
+		"temperature": 0.1,
+		"messages": [
+			{"role": "system", "content": "answer with a single number"},
+			{"role": "user", "content": "what is 2+2?"}
+		]
+	}`)
+
+	httpResp, err := client.client.Post(client.baseURL+"/v1/chat/completions", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer httpResp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(httpResp.Body)
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. response: %s", httpResp.StatusCode, string(bodyBytes))
+	}
+
+	var resp models.ChatCompletionResponse
+	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	if resp.Object != "chat.completion" {
+		t.Errorf("expected object chat.completion, got %s", resp.Object)
+	}
+
+	if len(resp.Choices) == 0 || resp.Choices[0].Message == nil || resp.Choices[0].Message.Content == "" {
+		t.Fatal("expected a choice with message content")
+	}
+
+	if resp.Usage == nil || resp.Usage.TotalTokens == 0 {
+		t.Error("expected usage in response")
+	}
+
+	if httpResp.Header.Get("X-Cost-Total") == "" {
+		t.Error("expected cost headers in response")
+	}
+
+	t.Log("✓ chat completion succeeded")
+	t.Logf("  response: %s", resp.Choices[0].Message.Content)
+}
+
+func TestStructuredOutputRequest(t *testing.T) {
+	client := newAPIClient()
+
+	temp := 0.1
+	req := models.GeminiRequest{
+		Contents: []models.Content{
+			{Parts: []models.Part{{Text: "what is 3+3?"}}},
+		},
+		SystemInstruction: &models.Content{
+			Parts: []models.Part{{Text: "answer with the number only"}},
+		},
+		GenerationConfig: models.GenerationConfig{
+			Temperature:      &temp,
+			ResponseMimeType: "application/json",
+			ResponseSchema:   json.RawMessage(`{"type":"OBJECT","properties":{"answer":{"type":"INTEGER"}},"required":["answer"]}`),
+		},
+	}
+
+	httpResp, bodyBytes, err := client.generateContent("gemini-2.0-flash", req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", httpResp.StatusCode, bodyBytes)
+	}
+
+	var resp models.GeminiResponse
+	json.Unmarshal(bodyBytes, &resp)
+
+	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
+		t.Fatal("expected at least one candidate in response")
+	}
+
+	// only holds if responseSchema reached gemini
+	var answer struct {
+		Answer int `json:"answer"`
+	}
+	text := resp.Candidates[0].Content.Parts[0].Text
+	if err := json.Unmarshal([]byte(text), &answer); err != nil {
+		t.Fatalf("expected json matching the schema, got %q", text)
+	}
+
+	if answer.Answer != 6 {
+		t.Errorf("expected answer 6, got %d", answer.Answer)
+	}
+
+	t.Logf("✓ structured output request succeeded: %s", text)
+}
+
 func TestVisionRequest(t *testing.T) {
 	client := newAPIClient()
 	optimizer := NewImageOptimizer()